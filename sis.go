@@ -1,25 +1,33 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"image"
 	"io"
 	"log"
-	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/DDHax/sis/store"
+	"github.com/DDHax/sis/store/graphics"
 )
 
 //文件大小上限，此参数将设置为接收图片时分配的内存上限
@@ -36,23 +44,82 @@ const (
 	minHeight = 5
 )
 
-var zeroTime time.Time
+//单次请求最多执行的变换数，也是处理管道的步骤上限
+const maxOps = 10
+
+//列表接口每页数量
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+//签名及过期时间参数名
+const (
+	sigParam = "sig"
+	expParam = "exp"
+)
+
+//签名密钥，为空时不校验签名
+var secret []byte
+
+//为true时读取原图无需签名，只校验图像处理请求
+var publicOriginal = true
+
+//上传回复中原图下载地址的签名有效期
+var urlTTL = time.Hour
+
+//为true时只允许按预设处理图像
+var presetsOnly bool
+
+//将上传内容写入临时文件，同时计算md5
+func receiveFile(r io.Reader) (f *os.File, md5Code string, err error) {
+	f, err = store.TempFile()
+	if err != nil {
+		return
+	}
 
-func saveFile(f multipart.File, fileName string) (md5Code string, err error) {
 	//计算文件MD5
 	h := md5.New()
-	if _, err = io.Copy(h, f); err != nil {
-		return
+	if _, err = io.Copy(io.MultiWriter(f, h), r); err != nil {
+		dropTemp(f)
+		return nil, "", err
 	}
 	ret := h.Sum(nil)
 
 	//16进制md5转字符串格式
 	md5Code = hex.EncodeToString(ret)
-
-	err = store.Write(f, md5Code, fileName)
 	return
 }
 
+//关闭并删除临时文件，已移动到位时删除会失败，忽略即可
+func dropTemp(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+func saveFile(r io.Reader, fileName string) (string, store.FileInfo, error) {
+	f, md5Code, err := receiveFile(r)
+	if err != nil {
+		return "", store.FileInfo{}, err
+	}
+	defer dropTemp(f)
+	info, err := store.Write(f, md5Code, fileName)
+	return md5Code, info, err
+}
+
+//原图的下载地址，读取原图需要签名时一并签名
+func downloadURL(md5Code, fileName string) string {
+	u := imagesPath + md5Code + "/" + url.PathEscape(fileName)
+	if len(secret) == 0 || publicOriginal {
+		return u
+	}
+	signed, err := signURL(u, urlTTL)
+	if err != nil {
+		return u
+	}
+	return signed
+}
+
 //检测文件名合法性,包括长度和安全性检测
 func checkFileName(inputFileName string) bool {
 	if len(inputFileName) > maxFileNameLength ||
@@ -63,6 +130,190 @@ func checkFileName(inputFileName string) bool {
 	return true
 }
 
+//检测md5合法性,必须为32位16进制字符串
+func checkMD5(md5Code string) bool {
+	if len(md5Code) != md5.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(md5Code)
+	return err == nil
+}
+
+//检测md5前缀合法性,允许为空
+func checkMD5Prefix(prefix string) bool {
+	if len(prefix) > md5.Size*2 {
+		return false
+	}
+	for _, c := range prefix {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+//请求ID回复头，客户端带上时沿用，便于与日志关联
+const requestIDHeader = "X-Request-ID"
+
+//错误说明，按Accept-Language选择中文或英文
+type errorText struct {
+	zh, en string
+}
+
+//出错时的状态码、错误代码及说明
+type apiError struct {
+	status int
+	code   string
+	text   errorText
+}
+
+//各类错误，code供客户端判断错误类型，不随语言变化
+var (
+	errBadRequest       = apiError{400, "bad_request", errorText{"请求参数错误", "invalid request parameters"}}
+	errBadFileName      = apiError{400, "bad_file_name", errorText{"文件名长度超出50字节限制", "file name exceeds 50 bytes"}}
+	errTooManySteps     = apiError{400, "bad_request", errorText{"处理步骤过多", "too many processing steps"}}
+	errForbidden        = apiError{403, "forbidden", errorText{"签名错误或已过期", "invalid or expired signature"}}
+	errPresetOnly       = apiError{403, "preset_required", errorText{"仅支持预设的处理参数", "only presets are allowed"}}
+	errNotFound         = apiError{404, "not_found", errorText{"文件不存在", "file not found"}}
+	errMethod           = apiError{405, "method_not_allowed", errorText{"不支持的请求方法", "method not allowed"}}
+	errUploadTooLarge   = apiError{413, "too_large", errorText{"上传文件超出50M限制", "upload exceeds the 50M limit"}}
+	errTooLarge         = apiError{413, "too_large", errorText{"文件超出大小限制", "file too large"}}
+	errTooManyPixels    = apiError{413, "too_many_pixels", errorText{"图像像素数超出限制", "image has too many pixels"}}
+	errBadFormat        = apiError{415, "unsupported_format", errorText{"不是有效的图像文件", "not a valid image"}}
+	errFormatNotAllowed = apiError{415, "format_not_allowed", errorText{"图像格式不在允许列表中", "image format not allowed"}}
+	errOutputTooLarge   = apiError{422, "output_too_large", errorText{"处理结果像素数超出上限", "output image too large"}}
+	errInternal         = apiError{500, "internal_error", errorText{"服务内部错误", "internal server error"}}
+	errBackend          = apiError{502, "backend_error", errorText{"存储服务不可用", "storage backend unavailable"}}
+)
+
+//附加详细说明，如处理管道各步骤的错误
+func (e apiError) with(detail string) apiError {
+	e.text.zh += "：" + detail
+	e.text.en += ": " + detail
+	return e
+}
+
+//按请求的语言取说明
+func (e apiError) message(req *http.Request) string {
+	if preferEnglish(req) {
+		return e.text.en
+	}
+	return e.text.zh
+}
+
+//store返回的错误对应的回复，未知错误为500
+func storeError(err error) apiError {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return errNotFound
+	case errors.Is(err, store.ErrBadOption):
+		return errBadRequest
+	case errors.Is(err, store.ErrTooManyPixels):
+		return errTooManyPixels
+	case errors.Is(err, store.ErrTooLarge):
+		return errTooLarge
+	case errors.Is(err, store.ErrOutputTooLarge):
+		return errOutputTooLarge
+	case errors.Is(err, store.ErrBadFormat):
+		return errBadFormat
+	case errors.Is(err, store.ErrFormatNotAllowed):
+		return errFormatNotAllowed
+	case errors.Is(err, store.ErrBackend):
+		return errBackend
+	}
+	return errInternal
+}
+
+//按Accept-Language选择说明的语言，只区分中文和英文，缺省为中文
+func preferEnglish(req *http.Request) bool {
+	best, english := 0.0, false
+	for _, item := range strings.Split(req.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+
+		var en bool
+		switch tag = strings.ToLower(tag); {
+		case tag == "zh" || strings.HasPrefix(tag, "zh-"):
+		case tag == "en" || strings.HasPrefix(tag, "en-"):
+			en = true
+		default:
+			continue
+		}
+
+		//权重相同时以先出现的为准
+		if q > best {
+			best, english = q, en
+		}
+	}
+	return english
+}
+
+//错误回复内容
+type errorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+//以JSON格式回复错误
+func writeError(w http.ResponseWriter, req *http.Request, e apiError) {
+	h := w.Header()
+	id := h.Get(requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+		h.Set(requestIDHeader, id)
+	}
+	data, _ := json.Marshal(errorBody{Code: e.code, Message: e.message(req), RequestID: id})
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(e.status)
+	w.Write(data)
+}
+
+//记录store返回的错误并回复
+func writeStoreError(w http.ResponseWriter, req *http.Request, err error) {
+	log.Printf("%s %v", w.Header().Get(requestIDHeader), err)
+	writeError(w, req, storeError(err))
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//客户端传入的请求ID只接受较短的字母数字及-_.
+func checkRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+//为每个请求分配ID并写入回复头
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !checkRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, req)
+	})
+}
+
 func uploadHandler(w http.ResponseWriter, req *http.Request) {
 	//这个必须得有，客户端问的时候总要回答一下，否则测试页面无法工作
 	if strings.ToUpper(req.Method) == "OPTIONS" {
@@ -71,79 +322,126 @@ func uploadHandler(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(204)
 		return
 	}
+	if strings.ToUpper(req.Method) != "POST" {
+		w.Header().Set("Allow", "POST, OPTIONS")
+		writeError(w, req, errMethod)
+		return
+	}
+	if !checkWrite(w, req) {
+		return
+	}
 
-	//响应
-	status := 400
-	message := "不要乱来"
-	defer func(w http.ResponseWriter) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.WriteHeader(status)
-		w.Write([]byte(message))
-	}(w)
-
-	//处理上传请求
-	if strings.ToUpper(req.Method) == "POST" {
-		//文件大小检查
-		length, _ := strconv.Atoi(req.Header.Get("Content-Length"))
-		if length > maxFileSize {
-			status = 413
-			message = "上传文件超出50M限制"
+	//文件大小检查
+	length, _ := strconv.Atoi(req.Header.Get("Content-Length"))
+	if length > maxFileSize {
+		writeError(w, req, errUploadTooLarge)
+		return
+	}
+
+	//逐个part流式读取，不在内存中缓存整个请求
+	req.Body = http.MaxBytesReader(w, req.Body, maxFileSize)
+	reader, err := req.MultipartReader()
+	if err != nil {
+		log.Print(err)
+		writeError(w, req, errBadRequest)
+		return
+	}
+
+	//逐个文件保存，单个文件出错不影响其他文件，以第一个错误为全部失败时的状态码
+	results := []uploadResult{}
+	status := 200
+	failed := 0
+	fail := func(name string, e apiError) {
+		if failed == 0 {
+			status = e.status
+		}
+		failed++
+		results = append(results, uploadResult{Name: name, Code: e.code, Error: e.message(req)})
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if tooLarge(err) {
+			writeError(w, req, errUploadTooLarge)
 			return
 		}
-
-		//解释请求
-		err := req.ParseMultipartForm(int64(length))
 		if err != nil {
 			log.Print(err)
+			writeError(w, req, errBadRequest)
 			return
 		}
 
-		//处理请求
-		if req.MultipartForm != nil {
-			//初始化返回值
-			var messageBuf bytes.Buffer
-			messageBuf.WriteString(`[`)
-			for _, v := range req.MultipartForm.File {
-				for _, fileHead := range v {
-
-					//文件名长度检查
-					if !checkFileName(fileHead.Filename) {
-						status = 413
-						message = "文件名长度超出50字节限制"
-						return
-					}
-
-					//将上传的文件buffer转为文件接口
-					file, err := fileHead.Open()
-					if err != nil {
-						status = 500
-						message = "发生诡异错误"
-						return
-					}
-
-					//保存文件
-					md5Code, err := saveFile(file, fileHead.Filename)
-					if err != nil {
-						status = 500
-						message = "创建文件失败"
-						return
-					}
-
-					//写入json格式返回值
-					if messageBuf.Len() > 1 {
-						messageBuf.WriteByte(',')
-					}
-					messageBuf.WriteString(`{"Name":"`)
-					messageBuf.WriteString(fileHead.Filename)
-					messageBuf.WriteString(`", "MD5":"`)
-					messageBuf.WriteString(md5Code)
-					messageBuf.WriteString(`"}`)
-				}
-			}
-			messageBuf.WriteString("]")
-			status = 200
-			message = messageBuf.String()
+		//跳过普通表单字段
+		fileName := part.FileName()
+		if fileName == "" {
+			part.Close()
+			continue
 		}
+
+		//文件名长度检查
+		if !checkFileName(fileName) {
+			part.Close()
+			fail(fileName, errBadFileName)
+			continue
+		}
+
+		//保存文件
+		md5Code, info, err := saveFile(part, fileName)
+		part.Close()
+		if tooLarge(err) {
+			writeError(w, req, errUploadTooLarge)
+			return
+		}
+		if err != nil {
+			log.Printf("%s %v", w.Header().Get(requestIDHeader), err)
+			fail(fileName, storeError(err))
+			continue
+		}
+		results = append(results, newUploadResult(md5Code, info))
+	}
+
+	//部分成功时回复200
+	if failed < len(results) {
+		status = 200
+	}
+	data, _ := json.Marshal(results)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+//请求体是否超出MaxBytesReader的限制
+func tooLarge(err error) bool {
+	var e *http.MaxBytesError
+	return errors.As(err, &e)
+}
+
+//单个文件的上传结果，出错时只有Name、Code和Error
+type uploadResult struct {
+	Name   string
+	MD5    string `json:",omitempty"`
+	Size   int64  `json:",omitempty"` //文件字节数
+	Format string `json:",omitempty"` //图像格式
+	Width  int    `json:",omitempty"`
+	Height int    `json:",omitempty"`
+	URL    string `json:",omitempty"` //原图下载地址
+	Code   string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+//上传成功的结果
+func newUploadResult(md5Code string, info store.FileInfo) uploadResult {
+	return uploadResult{
+		Name:   info.Name,
+		MD5:    md5Code,
+		Size:   info.Size,
+		Format: info.Format,
+		Width:  info.Width,
+		Height: info.Height,
+		URL:    downloadURL(md5Code, info.Name),
 	}
 }
 
@@ -155,87 +453,233 @@ func derectUploadHandler(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(204)
 		return
 	}
+	if strings.ToUpper(req.Method) != "POST" {
+		w.Header().Set("Allow", "POST, OPTIONS")
+		writeError(w, req, errMethod)
+		return
+	}
+	if !checkWrite(w, req) {
+		return
+	}
 
-	//响应
-	status := 400
-	message := "不要乱来"
-	defer func(w http.ResponseWriter) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.WriteHeader(status)
-		w.Write([]byte(message))
-	}(w)
-
-	//处理上传请求
-	if strings.ToUpper(req.Method) == "POST" {
+	//只取第一个文件，表单字段名为md5
+	req.Body = http.MaxBytesReader(w, req.Body, maxFileSize)
+	reader, err := req.MultipartReader()
+	if err != nil {
+		log.Print(err)
+		writeError(w, req, errBadRequest)
+		return
+	}
+	part, err := reader.NextPart()
+	for err == nil && part.FileName() == "" {
+		part, err = reader.NextPart()
+	}
+	if err != nil {
+		log.Print(err)
+		writeError(w, req, errBadRequest)
+		return
+	}
+	defer part.Close()
+	if !checkMD5(part.FormName()) {
+		writeError(w, req, errBadRequest)
+		return
+	}
 
-		//解释请求
-		length, _ := strconv.Atoi(req.Header.Get("Content-Length"))
-		err := req.ParseMultipartForm(int64(length))
-		if err != nil {
-			log.Print(err)
-			return
-		}
+	//写入临时文件后保存
+	f, md5Code, err := receiveFile(part)
+	if tooLarge(err) {
+		writeError(w, req, errUploadTooLarge)
+		return
+	}
+	if err != nil {
+		log.Print(err)
+		writeError(w, req, errInternal)
+		return
+	}
+	defer dropTemp(f)
 
-		//处理请求
-		if req.MultipartForm != nil {
-			for k, v := range req.MultipartForm.File {
-				if len(v) > 0 {
-					file, err := v[0].Open()
-					if err != nil {
-						status = 500
-						message = "发生诡异错误"
-						return
-					}
-
-					//保存文件
-					err = store.Write(file, k, v[0].Filename)
-					if err != nil {
-						status = 500
-						message = "创建文件失败"
-						return
-					}
-				}
-				break
-			}
-			message = "上传完成"
-			status = 200
-		}
+	//字段名须与文件内容的md5一致，否则按错误的md5存储后无法按内容寻址
+	if md5Code != part.FormName() {
+		writeError(w, req, errBadRequest.with("md5与文件内容不一致"))
+		return
 	}
+	if _, err := store.Write(f, md5Code, part.FileName()); err != nil {
+		writeStoreError(w, req, err)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write([]byte("上传完成"))
 }
 
 func simpleDownHandler(w http.ResponseWriter, req *http.Request) {
 	//参数解释
-	req.ParseForm()
+	query := req.URL.Query()
 
 	//读取文件
-	md5Code := req.FormValue("md5")
-	var fileName string
-	data, err := store.Read(md5Code, &fileName, 0, 0)
-	if err != nil {
-		log.Print(err)
-		w.WriteHeader(404)
+	md5Code := query.Get("md5")
+	opt, ret := parseOption(req, false)
+	if !ret {
+		writeError(w, req, errBadRequest)
 		return
 	}
 
-	//回复文件
-	http.ServeContent(w, req, fileName, zeroTime, bytes.NewReader(data))
+	//检查权限后回复文件
+	serveRead(w, req, md5Code, "", opt)
 }
 
+//检测整数参数范围，空字符串表示缺省
+func checkLength(s string, min, max int) (int, bool) {
+	if s == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min || n > max {
+		return 0, false
+	}
+	return n, true
+}
+
+//检测缩放尺寸，宽高允许缺省其一，由原图比例推算
 func checkParam(w, h string) (int, int, bool) {
-	intW, err := strconv.Atoi(w)
-	if err != nil {
+	if w == "" && h == "" {
 		return 0, 0, false
 	}
-	intH, err := strconv.Atoi(h)
-	if err != nil {
+	intW, ret := checkLength(w, minWidth, maxWidth)
+	if !ret {
 		return 0, 0, false
 	}
-	if intW < minWidth || intW > maxWidth || intH < minHeight || intH > maxHeight {
+	intH, ret := checkLength(h, minHeight, maxHeight)
+	if !ret {
 		return 0, 0, false
 	}
 	return intW, intH, true
 }
 
+//解释图像处理参数，stretch为true时解释缩放参数。
+//签名只覆盖路径和查询串，参数只从查询串读取，POST请求体不能改变已签名的参数
+func parseOption(req *http.Request, stretch bool) (store.Option, bool) {
+	query := req.URL.Query()
+
+	//使用预设时不再需要缩放参数
+	if name := query.Get("preset"); name != "" {
+		return parsePreset(req, name)
+	}
+
+	var opt store.Option
+
+	//输出格式和质量
+	opt.Format = query.Get("format")
+	quality, ret := checkLength(query.Get("q"), 1, 100)
+	if !ret {
+		return opt, false
+	}
+	opt.Quality = quality
+
+	//autorotate=0时不按exif方向摆正
+	switch query.Get("autorotate") {
+	case "", "1":
+	case "0":
+		opt.NoRotate = true
+	default:
+		return opt, false
+	}
+
+	//缩放参数
+	if stretch {
+		intW, intH, ret := checkParam(query.Get("w"), query.Get("h"))
+		if !ret {
+			return opt, false
+		}
+		bg, err := store.ParseColor(query.Get("bg"))
+		if err != nil {
+			return opt, false
+		}
+		opt.Width = intW
+		opt.Height = intH
+		opt.Mode = query.Get("mode")
+		opt.Background = bg
+		opt.Filter = query.Get("filter")
+	}
+	return opt, opt.Valid()
+}
+
+//按名称取预设，除autorotate外不接受其他处理参数
+func parsePreset(req *http.Request, name string) (store.Option, bool) {
+	query := req.URL.Query()
+	opt, ok := store.Preset(name)
+	if !ok {
+		return opt, false
+	}
+	for _, k := range []string{"w", "h", "mode", "bg", "filter", "format", "q"} {
+		if query.Get(k) != "" {
+			return opt, false
+		}
+	}
+	switch query.Get("autorotate") {
+	case "", "1":
+	case "0":
+		opt.NoRotate = true
+	default:
+		return opt, false
+	}
+	return opt, true
+}
+
+//检查预设限制及签名后读取文件并回复，各下载接口共用，fileName为空时读取md5下的第一个文件
+func serveRead(w http.ResponseWriter, req *http.Request, md5Code, fileName string, opt store.Option) {
+	if !checkAccess(w, req, opt) {
+		return
+	}
+	f, err := store.Read(md5Code, &fileName, opt)
+	if err != nil {
+		writeStoreError(w, req, err)
+		return
+	}
+	serveImage(w, req, fileName, opt, f)
+}
+
+//回复图像并关闭文件，Content-Type按内容判断，格式转换后文件扩展名不再可靠
+//由http.ServeContent按需读取并处理If-None-Match等条件请求
+func serveImage(w http.ResponseWriter, req *http.Request, fileName string, opt store.Option, f *store.File) {
+	defer f.Close()
+	h := w.Header()
+	h.Set("Content-Type", http.DetectContentType(f.Head(512)))
+	h.Set("Cache-Control", cacheControl(req, opt))
+	if f.ETag != "" {
+		h.Set("ETag", f.ETag)
+	}
+	http.ServeContent(w, req, fileName, f.ModTime, f)
+}
+
+//原图内容由md5确定，可长期缓存；处理结果随处理实现可能变化，缓存时间较短
+const (
+	originalMaxAge  = 365 * 24 * time.Hour
+	processedMaxAge = 24 * time.Hour
+)
+
+//缓存策略，带过期时间的签名URL不能缓存到过期之后
+func cacheControl(req *http.Request, opt store.Option) string {
+	maxAge := originalMaxAge
+	if opt.Processed() {
+		maxAge = processedMaxAge
+	}
+	if len(secret) > 0 {
+		if exp, err := strconv.ParseInt(req.URL.Query().Get(expParam), 10, 64); err == nil {
+			left := time.Until(time.Unix(exp, 0))
+			if left < 0 {
+				left = 0
+			}
+			if left < maxAge {
+				return "public, max-age=" + strconv.Itoa(int(left.Seconds()))
+			}
+		}
+	}
+	if opt.Processed() {
+		return "public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	}
+	return "public, max-age=" + strconv.Itoa(int(maxAge.Seconds())) + ", immutable"
+}
+
 func loadImage(path string) (img image.Image, err error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -249,82 +693,549 @@ func loadImage(path string) (img image.Image, err error) {
 
 func stretchSimpleDownHandler(w http.ResponseWriter, req *http.Request) {
 	//参数解释
-	req.ParseForm()
+	query := req.URL.Query()
 
 	//检测参数合法性
-	md5Code := req.FormValue("md5")
-	height := req.FormValue("h")
-	width := req.FormValue("w")
-	intW, intH, ret := checkParam(width, height)
+	md5Code := query.Get("md5")
+	opt, ret := parseOption(req, true)
 	if !ret {
-		w.WriteHeader(404)
+		writeError(w, req, errBadRequest)
 		return
 	}
 
-	//获取原始文件
+	//检查权限后回复文件
+	serveRead(w, req, md5Code, "", opt)
+}
+
+func fullDownHandler(w http.ResponseWriter, req *http.Request) {
+	//参数解释
+	query := req.URL.Query()
+
+	//定位目录
+	md5Code := query.Get("md5")
+	fileName := query.Get("file_name")
+	if !checkFileName(fileName) {
+		writeError(w, req, errBadFileName)
+		return
+	}
+	opt, ret := parseOption(req, false)
+	if !ret {
+		writeError(w, req, errBadRequest)
+		return
+	}
+
+	//检查权限后回复文件
+	serveRead(w, req, md5Code, fileName, opt)
+}
+
+func stretchFullDownHandler(w http.ResponseWriter, req *http.Request) {
+	//参数解释
+	query := req.URL.Query()
+
+	//取参
+	md5Code := query.Get("md5")
+	fileName := query.Get("file_name")
+	if !checkFileName(fileName) {
+		writeError(w, req, errBadFileName)
+		return
+	}
+	opt, ret := parseOption(req, true)
+	if !ret {
+		writeError(w, req, errBadRequest)
+		return
+	}
+
+	//检查权限后回复文件
+	serveRead(w, req, md5Code, fileName, opt)
+}
+
+//变换接口，crop、rotate、flip按参数出现的顺序执行，之后可选缩放
+func transformHandler(w http.ResponseWriter, req *http.Request) {
+	//参数解释
+	query := req.URL.Query()
+
+	//文件名可缺省，与simple_down一致
+	md5Code := query.Get("md5")
+	fileName := query.Get("file_name")
+	if fileName != "" && !checkFileName(fileName) {
+		writeError(w, req, errBadFileName)
+		return
+	}
+
+	opt, ret := parseTransform(req)
+	if !ret {
+		writeError(w, req, errBadRequest)
+		return
+	}
+
+	//检查权限后回复文件
+	serveRead(w, req, md5Code, fileName, opt)
+}
+
+//解释查询串中的处理参数，宽高均缺省时只变换不缩放，使用预设时不能再有变换
+func parseTransform(req *http.Request) (store.Option, bool) {
+	query := req.URL.Query()
+	opt, ret := parseOption(req, query.Get("w") != "" || query.Get("h") != "")
+	if !ret {
+		return opt, false
+	}
+	ops, ret := parseOps(req)
+	if !ret || (len(ops) > 0 && query.Get("preset") != "") {
+		return opt, false
+	}
+	opt.Ops = append(opt.Ops, ops...)
+	return opt, true
+}
+
+//按出现顺序解释变换参数，url.Values不保留顺序，需直接解析查询串
+func parseOps(req *http.Request) ([]store.Op, bool) {
+	query := req.URL.Query()
+	bg, err := store.ParseColor(query.Get("bg"))
+	if err != nil {
+		return nil, false
+	}
+
+	var ops []store.Op
+	for _, kv := range strings.Split(req.URL.RawQuery, "&") {
+		k, v, _ := strings.Cut(kv, "=")
+		k, err := url.QueryUnescape(k)
+		if err != nil {
+			return nil, false
+		}
+		if k != "crop" && k != "rotate" && k != "flip" {
+			continue
+		}
+		v, err = url.QueryUnescape(v)
+		if err != nil || len(ops) >= maxOps {
+			return nil, false
+		}
+		op, err := store.ParseOp(k, v, bg)
+		if err != nil {
+			log.Print(err)
+			return nil, false
+		}
+		ops = append(ops, op)
+	}
+	return ops, true
+}
+
+//处理管道接口，路径为/img/{步骤}/.../{md5}[/{文件名}]，如
+// /img/rs:fill:300:200/q:80/f:png/rot:90/{md5}/{文件名}
+func imgHandler(w http.ResponseWriter, req *http.Request) {
+	//含:的前缀段为处理步骤，之后依次为md5和可选的文件名
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/img/"), "/")
+	n := 0
+	for n < len(parts) && strings.Contains(parts[n], ":") {
+		n++
+	}
+	pipeline, rest := parts[:n], parts[n:]
+	if len(rest) == 0 || len(rest) > 2 || !checkMD5(rest[0]) {
+		writeError(w, req, errBadRequest)
+		return
+	}
+	md5Code := rest[0]
 	var fileName string
-	data, err := store.Read(md5Code, &fileName, intW, intH)
+	if len(rest) == 2 {
+		fileName = rest[1]
+		if !checkFileName(fileName) {
+			writeError(w, req, errBadFileName)
+			return
+		}
+	}
+	if len(pipeline) > maxOps {
+		writeError(w, req, errTooManySteps)
+		return
+	}
+
+	//逐步解析，出错时回复每一步的错误
+	opt, err := store.ParsePipeline(pipeline)
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(404)
+		writeError(w, req, errBadRequest.with(err.Error()))
 		return
 	}
 
-	//回复文件
-	http.ServeContent(w, req, fileName, zeroTime, bytes.NewReader(data))
+	//检查权限后回复文件
+	serveRead(w, req, md5Code, fileName, opt)
 }
 
-func fullDownHandler(w http.ResponseWriter, req *http.Request) {
-	//参数解释
+//计算签名，HMAC-SHA256后做url安全的base64编码
+func signature(msg string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(msg))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+//签名内容为路径加去掉sig后的原始查询串，md5、文件名、处理参数及过期时间均在其中
+func signedMessage(u *url.URL) (msg, sig string) {
+	var kept []string
+	for _, kv := range strings.Split(u.RawQuery, "&") {
+		switch {
+		case strings.HasPrefix(kv, sigParam+"="):
+			sig = kv[len(sigParam)+1:]
+		case kv != "":
+			kept = append(kept, kv)
+		}
+	}
+	msg = u.EscapedPath()
+	if len(kept) > 0 {
+		msg = msg + "?" + strings.Join(kept, "&")
+	}
+	return msg, sig
+}
+
+//写操作的签名内容带请求方法，读取用的签名地址不能用于删除或覆盖同一路径
+func methodMessage(method, msg string) string {
+	if method == "GET" || method == "HEAD" {
+		return msg
+	}
+	return method + " " + msg
+}
+
+//为路径加查询串签名，ttl大于0时附加过期时间
+func signURL(rawURL string, ttl time.Duration) (string, error) {
+	return signRequest("GET", rawURL, ttl)
+}
+
+//为指定方法的请求签名
+func signRequest(method, rawURL string, ttl time.Duration) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if ttl > 0 {
+		exp := expParam + "=" + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+		if u.RawQuery != "" {
+			exp = "&" + exp
+		}
+		u.RawQuery += exp
+	}
+
+	msg, _ := signedMessage(u)
+	sep := "?"
+	if strings.Contains(msg, "?") {
+		sep = "&"
+	}
+	return msg + sep + sigParam + "=" + signature(methodMessage(method, msg)), nil
+}
+
+//只允许预设时，其他处理请求回复403，之后校验签名
+func checkAccess(w http.ResponseWriter, req *http.Request, opt store.Option) bool {
+	if presetsOnly && opt.Processed() && !store.IsPreset(opt) {
+		writeError(w, req, errPresetOnly)
+		return false
+	}
+	return checkSignature(w, req, opt)
+}
+
+//校验请求签名，签名错误或已过期时回复403
+func checkSignature(w http.ResponseWriter, req *http.Request, opt store.Option) bool {
+	if len(secret) == 0 || (publicOriginal && !opt.Processed()) {
+		return true
+	}
+	return verifySignature(w, req, "GET")
+}
+
+//设置密钥后上传、删除一律校验签名，与原图是否公开无关
+func checkWrite(w http.ResponseWriter, req *http.Request) bool {
+	if len(secret) == 0 {
+		return true
+	}
+	return verifySignature(w, req, req.Method)
+}
+
+//按method校验签名及过期时间，失败时回复403，读取接口不区分请求方法
+func verifySignature(w http.ResponseWriter, req *http.Request, method string) bool {
+	msg, sig := signedMessage(req.URL)
+	if sig == "" || !hmac.Equal([]byte(sig), []byte(signature(methodMessage(method, msg)))) {
+		writeError(w, req, errForbidden)
+		return false
+	}
+	if exp := req.URL.Query().Get(expParam); exp != "" {
+		t, err := strconv.ParseInt(exp, 10, 64)
+		if err != nil || time.Now().Unix() > t {
+			writeError(w, req, errForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+func deleteHandler(w http.ResponseWriter, req *http.Request) {
+	//这个必须得有，客户端问的时候总要回答一下，否则测试页面无法工作
+	if strings.ToUpper(req.Method) == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Method", "DELETE, POST")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(204)
+		return
+	}
+
+	method := strings.ToUpper(req.Method)
+	if method != "DELETE" && method != "POST" {
+		w.Header().Set("Allow", "DELETE, POST")
+		writeError(w, req, errMethod)
+		return
+	}
+	if !checkWrite(w, req) {
+		return
+	}
+
+	//参数解释，需要签名时只从已签名的查询串读取
 	req.ParseForm()
+	params := req.Form
+	if len(secret) > 0 {
+		params = req.URL.Query()
+	}
+	md5Code := params.Get("md5")
+	fileName := params.Get("file_name")
+	if !checkMD5(md5Code) || (fileName != "" && !checkFileName(fileName)) {
+		writeError(w, req, errBadRequest)
+		return
+	}
 
-	//定位目录
-	md5Code := req.FormValue("md5")
-	fileName := req.FormValue("file_name")
-	if !checkFileName(fileName) {
-		w.WriteHeader(404)
+	//删除文件
+	if !removeImage(w, req, md5Code, fileName) {
 		return
 	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write([]byte("删除完成"))
+}
+
+//删除文件，fileName为空时删除md5下全部文件，失败时回复错误并返回false
+func removeImage(w http.ResponseWriter, req *http.Request, md5Code, fileName string) bool {
+	if err := store.Delete(md5Code, fileName); err != nil {
+		writeStoreError(w, req, err)
+		return false
+	}
+	return true
+}
 
-	data, err := store.Read(md5Code, &fileName, 0, 0)
+//以JSON格式回复
+func writeJSON(w http.ResponseWriter, req *http.Request, v interface{}) {
+	data, err := json.Marshal(v)
 	if err != nil {
-		w.WriteHeader(404)
+		log.Print(err)
+		writeError(w, req, errInternal)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(data)
+}
 
-	//回复文件
-	http.ServeContent(w, req, fileName, zeroTime, bytes.NewReader(data))
+func listHandler(w http.ResponseWriter, req *http.Request) {
+	//参数解释
+	req.ParseForm()
+
+	//检测参数合法性
+	prefix := req.FormValue("prefix")
+	cursor := req.FormValue("cursor")
+	if !checkMD5Prefix(prefix) || !checkMD5Prefix(cursor) {
+		writeError(w, req, errBadRequest)
+		return
+	}
+	limit := defaultListLimit
+	if v := req.FormValue("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			writeError(w, req, errBadRequest)
+			return
+		}
+	}
+
+	//读取列表
+	page, err := store.List(prefix, cursor, limit)
+	if err != nil {
+		writeStoreError(w, req, err)
+		return
+	}
+	if page.Items == nil {
+		page.Items = []string{}
+	}
+	writeJSON(w, req, page)
 }
 
-func stretchFullDownHandler(w http.ResponseWriter, req *http.Request) {
+func metaHandler(w http.ResponseWriter, req *http.Request) {
 	//参数解释
 	req.ParseForm()
 
-	//取参
 	md5Code := req.FormValue("md5")
-	fileName := req.FormValue("file_name")
-	height := req.FormValue("h")
-	width := req.FormValue("w")
-	if !checkFileName(fileName) {
-		w.WriteHeader(404)
+	if !checkMD5(md5Code) {
+		writeError(w, req, errBadRequest)
+		return
+	}
+
+	//读取元数据
+	meta, err := store.Stat(md5Code)
+	if err != nil {
+		writeStoreError(w, req, err)
 		return
 	}
-	intW, intH, ret := checkParam(width, height)
+	writeJSON(w, req, meta)
+}
+
+//REST接口路径前缀
+const imagesPath = "/v1/images/"
+
+//路由处理函数，params为路径参数
+type routeHandler func(w http.ResponseWriter, req *http.Request, params map[string]string)
+
+//路由规则，pattern按/分段，{}包围的段为路径参数
+type route struct {
+	method  string
+	pattern []string
+	handler routeHandler
+}
+
+//按方法和路径分发的简单路由，GET规则同时处理HEAD
+type router struct {
+	routes []route
+}
+
+func (r *router) handle(method, pattern string, h routeHandler) {
+	r.routes = append(r.routes, route{method, strings.Split(strings.Trim(pattern, "/"), "/"), h})
+}
+
+//按段匹配路径，段内的转义字符解码后作为参数
+func (rt route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.pattern) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, p := range rt.pattern {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			v, err := url.PathUnescape(segments[i])
+			if err != nil || v == "" {
+				return nil, false
+			}
+			params[p[1:len(p)-1]] = v
+			continue
+		}
+		if p != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	//用转义后的路径分段，文件名中的%2F不会被当作分隔符
+	segments := strings.Split(strings.Trim(req.URL.EscapedPath(), "/"), "/")
+	method := req.Method
+	if method == "HEAD" {
+		method = "GET"
+	}
+
+	var allowed []string
+	for _, rt := range r.routes {
+		params, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+		if rt.method == method {
+			rt.handler(w, req, params)
+			return
+		}
+		allowed = append(allowed, rt.method)
+		if rt.method == "GET" {
+			allowed = append(allowed, "HEAD")
+		}
+	}
+
+	if len(allowed) == 0 {
+		writeError(w, req, errNotFound)
+		return
+	}
+	allowed = append(allowed, "OPTIONS")
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	if req.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(204)
+		return
+	}
+	writeError(w, req, errMethod)
+}
+
+//REST接口，旧接口保留并与之共用读取、删除及保存的实现
+func newAPI() *router {
+	api := &router{}
+	api.handle("GET", imagesPath+"{md5}", getImageHandler)
+	api.handle("GET", imagesPath+"{md5}/{name}", getImageHandler)
+	api.handle("DELETE", imagesPath+"{md5}", deleteImageHandler)
+	api.handle("DELETE", imagesPath+"{md5}/{name}", deleteImageHandler)
+	api.handle("PUT", imagesPath+"{name}", putImageHandler)
+	return api
+}
+
+//读取原图或处理结果，处理参数与/transform相同
+func getImageHandler(w http.ResponseWriter, req *http.Request, params map[string]string) {
+	md5Code, fileName := params["md5"], params["name"]
+	if !checkMD5(md5Code) {
+		writeError(w, req, errBadRequest)
+		return
+	}
+	if fileName != "" && !checkFileName(fileName) {
+		writeError(w, req, errBadFileName)
+		return
+	}
+	opt, ret := parseTransform(req)
 	if !ret {
-		w.WriteHeader(404)
+		writeError(w, req, errBadRequest)
 		return
 	}
+	serveRead(w, req, md5Code, fileName, opt)
+}
 
-	//获取文件
-	data, err := store.Read(md5Code, &fileName, intW, intH)
+//删除文件，未指定文件名时删除md5下全部文件
+func deleteImageHandler(w http.ResponseWriter, req *http.Request, params map[string]string) {
+	if !checkWrite(w, req) {
+		return
+	}
+	md5Code, fileName := params["md5"], params["name"]
+	if !checkMD5(md5Code) || (fileName != "" && !checkFileName(fileName)) {
+		writeError(w, req, errBadRequest)
+		return
+	}
+	if removeImage(w, req, md5Code, fileName) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(204)
+	}
+}
+
+//以请求体为文件内容上传，成功时回复201及文件信息
+func putImageHandler(w http.ResponseWriter, req *http.Request, params map[string]string) {
+	if !checkWrite(w, req) {
+		return
+	}
+	fileName := params["name"]
+	if !checkFileName(fileName) {
+		writeError(w, req, errBadFileName)
+		return
+	}
+	if req.ContentLength > maxFileSize {
+		writeError(w, req, errUploadTooLarge)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, maxFileSize)
+	md5Code, info, err := saveFile(req.Body, fileName)
+	if tooLarge(err) {
+		writeError(w, req, errUploadTooLarge)
+		return
+	}
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(404)
+		writeStoreError(w, req, err)
 		return
 	}
 
-	//回复文件
-	http.ServeContent(w, req, fileName, zeroTime, bytes.NewReader(data))
+	result := newUploadResult(md5Code, info)
+	w.Header().Set("Location", result.URL)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(201)
+	data, _ := json.Marshal(result)
+	w.Write(data)
 }
 
 func defaultHandler(w http.ResponseWriter, req *http.Request) {
@@ -339,18 +1250,73 @@ func main() {
 	http.HandleFunc("/full_down", fullDownHandler)
 	http.HandleFunc("/stretch_simple_down", stretchSimpleDownHandler)
 	http.HandleFunc("/stretch_full_down", stretchFullDownHandler)
+	http.HandleFunc("/transform", transformHandler)
+	http.HandleFunc("/img/", imgHandler)
+	http.HandleFunc("/delete", deleteHandler)
+	http.HandleFunc("/list", listHandler)
+	http.HandleFunc("/meta", metaHandler)
+	http.Handle(imagesPath, newAPI())
 
 	//参数解释
 	port := flag.String("port", "3333", "监听端口")
 	storeType := flag.Bool("localStore", true, "存储类型,true为本地存储，false为远程存储")
 	imagePath := flag.String("image", "image", "本地存储时表示本地目录，远程存储时表示远程主机地址")
 	cacheSize := flag.Int("cache", 100, "内存cache最大值，单位为M，0表示不启用")
+	workers := flag.Int("workers", runtime.NumCPU(), "图像缩放并发协程数上限，1表示串行")
+	key := flag.String("secret", os.Getenv("SIS_SECRET"), "签名密钥，默认读取环境变量SIS_SECRET，为空时不校验签名")
+	flag.BoolVar(&publicOriginal, "publicOriginal", true, "读取原图是否无需签名")
+	sign := flag.String("sign", "", "为指定的路径加查询串签名，输出后退出")
+	ttl := flag.Duration("ttl", 0, "签名有效期，0表示永不过期，与-sign配合使用")
+	method := flag.String("method", "GET", "签名的请求方法，与-sign配合使用，REST接口上传、删除时为PUT、DELETE")
+	flag.DurationVar(&urlTTL, "urlTTL", time.Hour, "上传回复中原图下载地址的签名有效期，-publicOriginal=false时有效")
+	presetConf := flag.String("presets", "", "预设，格式为 名称=处理管道;名称=处理管道，如 avatar=rs:fill:64:64;card=rs:fill:320:240/q:80")
+	flag.BoolVar(&presetsOnly, "presetsOnly", false, "只允许按预设处理图像")
+	warm := flag.Bool("warm", false, "上传成功后在后台预生成全部预设，需启用cache")
+	maxSrcMP := flag.Float64("maxSrcMP", 100, "原图像素数上限，单位为百万像素，0表示不限制")
+	maxDstMP := flag.Float64("maxDstMP", 50, "处理结果像素数上限，单位为百万像素，0表示不限制")
+	formats := flag.String("formats", "jpeg,png,gif", "允许上传的图像格式，以逗号分隔")
 	flag.Parse()
 
+	//签名工具模式
+	secret = []byte(*key)
+	if *sign != "" {
+		signed, err := signRequest(strings.ToUpper(*method), *sign, *ttl)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(signed)
+		return
+	}
+
+	//远程存储时读取原图及上传、删除同样需要签名
+	if len(secret) > 0 {
+		store.SetSigner(func(method, u string) string {
+			signed, err := signRequest(method, u, 0)
+			if err != nil {
+				return u
+			}
+			return signed
+		})
+	}
+
+	graphics.SetWorkers(*workers)
+	store.SetLimits(*maxSrcMP, *maxDstMP)
+	if err := store.SetFormats(strings.Split(*formats, ",")); err != nil {
+		log.Fatal(err)
+	}
+
 	store.Init(*imagePath, *storeType, *cacheSize)
 
+	//预设须在Init之后设置，预生成依赖cache
+	presets, err := store.ParsePresets(*presetConf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store.SetPresets(presets, *warm)
+
 	var srv http.Server
 	srv.Addr = ":" + *port
+	srv.Handler = withRequestID(http.DefaultServeMux)
 
 	//下面实现HTTP服务优雅退出，代码摘自官方文档
 	idleConnsClosed := make(chan struct{})
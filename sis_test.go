@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DDHax/sis/store"
+)
+
+func TestCheckSignature(t *testing.T) {
+	secret = []byte("test")
+	defer func() { secret = nil }()
+
+	const path = "/stretch_simple_down?md5=685264ff36effb53d7ecdb81d3b89b22&w=200&h=100"
+	signed, err := signURL(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _ := signURL(path+"&exp="+strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10), 0)
+	expiring, _ := signURL(path, time.Hour)
+	opt := store.Option{Width: 200, Height: 100}
+
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{signed, true},
+		{expiring, true},
+		{path, false},
+		//篡改尺寸
+		{strings.Replace(signed, "w=200", "w=201", 1), false},
+		//去掉过期时间
+		{strings.Replace(expiring, "&exp=", "&x=", 1), false},
+		{expired, false},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		ok := checkSignature(w, httptest.NewRequest("GET", test.url, nil), opt)
+		if ok != test.ok || (!ok && w.Code != 403) {
+			t.Errorf("%s: 预期[%v], 实际[%v %d]", test.url, test.ok, ok, w.Code)
+		}
+	}
+
+	//原图可按配置免签名
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/simple_down?md5=685264ff36effb53d7ecdb81d3b89b22", nil)
+	if !checkSignature(w, req, store.Option{}) {
+		t.Error("原图默认无需签名")
+	}
+	publicOriginal = false
+	defer func() { publicOriginal = true }()
+	if checkSignature(w, req, store.Option{}) {
+		t.Error("关闭publicOriginal后原图需要签名")
+	}
+}
+
+//原图需要签名时，上传回复中的下载地址带过期时间
+func TestDownloadURL(t *testing.T) {
+	secret = []byte("test")
+	publicOriginal = false
+	defer func() { secret, publicOriginal = nil, true }()
+
+	u := downloadURL("685264ff36effb53d7ecdb81d3b89b22", "a b.jpg")
+	req := httptest.NewRequest("GET", u, nil)
+	exp, err := strconv.ParseInt(req.URL.Query().Get(expParam), 10, 64)
+	if err != nil || exp > time.Now().Add(urlTTL).Unix() {
+		t.Errorf("非预期过期时间 %s", u)
+	}
+	if !checkSignature(httptest.NewRecorder(), req, store.Option{}) {
+		t.Errorf("签名校验失败 %s", u)
+	}
+}
+
+//签名只覆盖查询串，POST请求体不能改变处理参数
+func TestSignedParamsFromQuery(t *testing.T) {
+	secret = []byte("test")
+	defer func() { secret = nil }()
+
+	signed, err := signURL("/simple_down?md5=685264ff36effb53d7ecdb81d3b89b22&q=80", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", signed, strings.NewReader("q=1&w=5000"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	opt, ok := parseOption(req, false)
+	if !ok {
+		t.Fatal("解释参数失败")
+	}
+	if opt.Quality != 80 || opt.Width != 0 {
+		t.Errorf("预期[q=80 w=0], 实际[q=%d w=%d]", opt.Quality, opt.Width)
+	}
+	if !checkAccess(httptest.NewRecorder(), req, opt) {
+		t.Error("签名应校验通过")
+	}
+
+	opt, ok = parseTransform(req)
+	if !ok || opt.Quality != 80 || opt.Width != 0 {
+		t.Errorf("变换接口预期[q=80 w=0], 实际[%v q=%d w=%d]", ok, opt.Quality, opt.Width)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	const path = "/simple_down?md5=685264ff36effb53d7ecdb81d3b89b22"
+	req := httptest.NewRequest("GET", path, nil)
+	if got := cacheControl(req, store.Option{}); got != "public, max-age=31536000, immutable" {
+		t.Errorf("原图缓存策略错误 %s", got)
+	}
+	if got := cacheControl(req, store.Option{Width: 100}); got != "public, max-age=86400" {
+		t.Errorf("处理结果缓存策略错误 %s", got)
+	}
+
+	//签名URL不能缓存到过期之后
+	secret = []byte("test")
+	defer func() { secret = nil }()
+	exp := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	got := cacheControl(httptest.NewRequest("GET", path+"&exp="+exp, nil), store.Option{})
+	if !strings.HasPrefix(got, "public, max-age=") || strings.Contains(got, "immutable") {
+		t.Errorf("签名URL缓存策略错误 %s", got)
+	}
+	if age, _ := strconv.Atoi(strings.TrimPrefix(got, "public, max-age=")); age > 60 {
+		t.Errorf("缓存时间超过过期时间 %s", got)
+	}
+}
+
+func TestPreferEnglish(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"en", true},
+		{"zh-CN,zh;q=0.9,en;q=0.8", false},
+		{"en-US,zh;q=0.5", true},
+		{"fr,en;q=0.3,zh;q=0.7", false},
+		{"fr,en;q=0.3", true},
+		{"en;q=0", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Language", test.header)
+		if got := preferEnglish(req); got != test.want {
+			t.Errorf("%q: 预期[%v], 实际[%v]", test.header, test.want, got)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/simple_down", nil)
+	req.Header.Set(requestIDHeader, "abc-123")
+	withRequestID(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeStoreError(w, req, fmt.Errorf("%w：502 Bad Gateway", store.ErrBackend))
+	})).ServeHTTP(w, req)
+
+	var body errorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 502 || body.Code != "backend_error" || body.Message != "存储服务不可用" || body.RequestID != "abc-123" {
+		t.Errorf("非预期回复 %d %+v", w.Code, body)
+	}
+
+	//不合法的请求ID重新生成
+	w = httptest.NewRecorder()
+	req.Header.Set(requestIDHeader, "bad id\n")
+	withRequestID(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeError(w, req, errNotFound)
+	})).ServeHTTP(w, req)
+	if id := w.Header().Get(requestIDHeader); !checkRequestID(id) || id == "bad id\n" {
+		t.Errorf("非预期请求ID %q", id)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	var got map[string]string
+	handler := func(w http.ResponseWriter, req *http.Request, params map[string]string) {
+		got = params
+	}
+	api := &router{}
+	api.handle("GET", "/v1/images/{md5}/{name}", handler)
+	api.handle("DELETE", "/v1/images/{md5}/{name}", handler)
+
+	tests := []struct {
+		method, path string
+		code         int
+		name         string
+	}{
+		{"GET", "/v1/images/abc/a%20b.jpg", 200, "a b.jpg"},
+		{"HEAD", "/v1/images/abc/a.jpg", 200, "a.jpg"},
+		//转义的/不作为分隔符
+		{"DELETE", "/v1/images/abc/a%2Fb.jpg", 200, "a/b.jpg"},
+		{"PUT", "/v1/images/abc/a.jpg", 405, ""},
+		{"GET", "/v1/images/abc", 404, ""},
+		{"GET", "/v1/images/abc/a.jpg/x", 404, ""},
+	}
+	for _, test := range tests {
+		got = nil
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != test.code || (test.code == 200 && (got["md5"] != "abc" || got["name"] != test.name)) {
+			t.Errorf("%s %s: 预期[%d %s], 实际[%d %v]", test.method, test.path, test.code, test.name, w.Code, got)
+		}
+		if test.code == 405 && w.Header().Get("Allow") != "GET, HEAD, DELETE, OPTIONS" {
+			t.Errorf("非预期Allow %q", w.Header().Get("Allow"))
+		}
+	}
+}
+
+//设置密钥后删除、上传须带对应方法的签名
+func TestWriteSignature(t *testing.T) {
+	secret = []byte("test")
+	defer func() { secret = nil }()
+
+	const path = "/v1/images/685264ff36effb53d7ecdb81d3b89b22/a.jpg"
+	readURL, _ := signURL(path, 0)
+	deleteURL, _ := signRequest("DELETE", path, time.Hour)
+	expired, _ := signRequest("DELETE", path+"?exp="+strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10), 0)
+
+	api := newAPI()
+	for _, test := range []struct{ method, url string }{
+		{"DELETE", path},
+		//读取用的签名不能用于删除
+		{"DELETE", readURL},
+		{"DELETE", expired},
+		{"PUT", "/v1/images/a.jpg"},
+		{"PUT", strings.Replace(deleteURL, "/685264ff36effb53d7ecdb81d3b89b22", "", 1)},
+	} {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(test.method, test.url, strings.NewReader("x")))
+		if w.Code != 403 {
+			t.Errorf("%s %s: 预期[403], 实际[%d]", test.method, test.url, w.Code)
+		}
+	}
+
+	//旧接口同样需要签名
+	for _, test := range []struct {
+		method, url string
+		handler     http.HandlerFunc
+	}{
+		{"POST", "/up", uploadHandler},
+		{"POST", "/derect_up", derectUploadHandler},
+		{"DELETE", "/delete?md5=685264ff36effb53d7ecdb81d3b89b22", deleteHandler},
+		{"POST", "/delete?md5=685264ff36effb53d7ecdb81d3b89b22", deleteHandler},
+	} {
+		w := httptest.NewRecorder()
+		test.handler(w, httptest.NewRequest(test.method, test.url, strings.NewReader("x")))
+		if w.Code != 403 {
+			t.Errorf("%s %s: 预期[403], 实际[%d]", test.method, test.url, w.Code)
+		}
+	}
+
+	if !checkWrite(httptest.NewRecorder(), httptest.NewRequest("DELETE", deleteURL, nil)) {
+		t.Error("删除签名应校验通过")
+	}
+	if checkWrite(httptest.NewRecorder(), httptest.NewRequest("GET", deleteURL, nil)) {
+		t.Error("删除签名不能用于读取")
+	}
+}
+
+//单个文件出错不影响其他文件，全部失败时以第一个错误为状态码
+func TestUploadErrors(t *testing.T) {
+	store.Init(t.TempDir(), true, 0)
+	defer store.Init("", true, 0)
+
+	var buf bytes.Buffer
+	mt := multipart.NewWriter(&buf)
+	for _, name := range []string{"fake.jpg", strings.Repeat("a", 51) + ".jpg"} {
+		fw, _ := mt.CreateFormFile("upload_test", name)
+		fw.Write([]byte("<html></html>"))
+	}
+	mt.Close()
+	req := httptest.NewRequest("POST", "/up", &buf)
+	req.Header.Set("Content-Type", mt.FormDataContentType())
+
+	w := httptest.NewRecorder()
+	uploadHandler(w, req)
+	var results []uploadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	if w.Code != 415 || len(results) != 2 || results[0].Code != "unsupported_format" || results[1].Code != "bad_file_name" {
+		t.Errorf("非预期回复 %d %s", w.Code, w.Body)
+	}
+}
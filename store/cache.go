@@ -2,16 +2,29 @@ package store
 
 import (
 	"bytes"
+	"container/list"
 	"errors"
 	"io"
 	"mime/multipart"
+	"strings"
+	"sync"
+	"time"
 )
 
+//缓存项，modTime为原图的修改时间，命中时用于回复Last-Modified
+type cacheItem struct {
+	key     string
+	data    []byte
+	modTime time.Time
+}
+
+//LRU缓存，所有操作均由互斥锁保护，可被多个HTTP请求并发访问
 type cache struct {
-	data    map[string][]byte //图片缓存
-	keyList []string          //按照时间顺序排列的图片KEY列表
-	useSize int64             //缓存已用空间
-	maxSize int64             //最大缓存
+	mu      sync.Mutex
+	data    map[string]*list.Element //图片缓存，值为lru中对应的节点
+	lru     *list.List               //按最近访问时间排列的缓存项，表头为最近访问
+	useSize int64                    //缓存已用空间
+	maxSize int64                    //最大缓存
 }
 
 var gCache cache
@@ -23,7 +36,19 @@ func computeSize(keyLen, dataLen int) int {
 	return keyLen*2 + dataLen + extraLen
 }
 
-//准备cache空间，注意此函数仅在逻辑上释放已占用空间，真正的内存回收依赖Golang的GC
+//初始化缓存，maxSize为0表示不启用
+func (c *cache) init(maxSize int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxSize = maxSize
+	c.useSize = 0
+	c.data = make(map[string]*list.Element)
+	c.lru = list.New()
+}
+
+//准备cache空间，按最近最少使用原则淘汰，调用者必须持有锁
+//注意此函数仅在逻辑上释放已占用空间，真正的内存回收依赖Golang的GC
 func (c *cache) prepare(expectLen int) error {
 
 	//请求空间超出能力
@@ -31,29 +56,21 @@ func (c *cache) prepare(expectLen int) error {
 		return errors.New("缓存空间不足")
 	}
 
-	//剩余空间充足
-	if c.maxSize-c.useSize >= int64(expectLen) {
-		return nil
-	}
-
-	//释放空间
-	var releaseLen int
-	var releaseNum int
-	for _, key := range c.keyList {
-		releaseLen = releaseLen + computeSize(len(key), len(c.data[key]))
-		delete(c.data, key)
-
-		releaseNum = releaseNum + 1
-		if releaseLen >= expectLen {
-			break
-		}
+	//从表尾开始释放空间，直到剩余空间充足
+	for c.maxSize-c.useSize < int64(expectLen) {
+		c.removeElement(c.lru.Back())
 	}
-	c.keyList = c.keyList[releaseNum:]
-	c.useSize = c.useSize - int64(releaseLen)
 	return nil
 }
 
-func (c *cache) write(f multipart.File, md5 string, name string) error {
+//删除缓存项，调用者必须持有锁
+func (c *cache) removeElement(e *list.Element) {
+	item := c.lru.Remove(e).(*cacheItem)
+	delete(c.data, item.key)
+	c.useSize = c.useSize - int64(computeSize(len(item.key), len(item.data)))
+}
+
+func (c *cache) write(f multipart.File, md5 string, name string, modTime time.Time) error {
 	//此处必须Seek回起点，否则copy不到东西
 	_, err := f.Seek(0, 0)
 	if err != nil {
@@ -67,32 +84,32 @@ func (c *cache) write(f multipart.File, md5 string, name string) error {
 		return err
 	}
 
-	//准备缓存空间
-	key := md5 + name
-	err = c.prepare(computeSize(len(key), buf.Len()))
-	if err != nil {
-		return err
-	}
-
 	//写入缓存
-	c.derectWrite(key, buf.Bytes())
-	return nil
+	return c.memWrite(md5+name, buf.Bytes(), modTime)
 }
 
-func (c *cache) derectWrite(key string, data []byte) {
-	c.data[key] = data
-	c.keyList = append(c.keyList, key)
-	c.useSize = c.useSize + int64(computeSize(len(key), len(data)))
-}
+func (c *cache) read(key string) ([]byte, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-func (c *cache) read(key string) ([]byte, error) {
-	if v, ok := c.data[key]; ok {
-		return v, nil
+	if e, ok := c.data[key]; ok {
+		//命中后提升为最近访问
+		c.lru.MoveToFront(e)
+		item := e.Value.(*cacheItem)
+		return item.data, item.modTime, nil
 	}
-	return nil, errors.New("缓存未命中")
+	return nil, time.Time{}, errors.New("缓存未命中")
 }
 
-func (c *cache) memWrite(key string, data []byte) error {
+func (c *cache) memWrite(key string, data []byte, modTime time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	//覆盖已有缓存项时先释放旧空间
+	if e, ok := c.data[key]; ok {
+		c.removeElement(e)
+	}
+
 	//准备缓存空间
 	err := c.prepare(computeSize(len(key), len(data)))
 	if err != nil {
@@ -100,10 +117,23 @@ func (c *cache) memWrite(key string, data []byte) error {
 	}
 
 	//写入缓存
-	c.derectWrite(key, data)
+	c.data[key] = c.lru.PushFront(&cacheItem{key: key, data: data, modTime: modTime})
+	c.useSize = c.useSize + int64(computeSize(len(key), len(data)))
 	return nil
 }
 
-func (c cache) isEnable() bool {
+//删除所有以prefix开头的缓存项
+func (c *cache) removePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, e := range c.data {
+		if strings.HasPrefix(key, prefix) {
+			c.removeElement(e)
+		}
+	}
+}
+
+func (c *cache) isEnable() bool {
 	return c.maxSize > 0
 }
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestCache(maxSize int64) *cache {
+	c := new(cache)
+	c.init(maxSize)
+	return c
+}
+
+//校验已用空间与实际缓存项一致
+func checkAccounting(t *testing.T, c *cache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var size int64
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		item := e.Value.(*cacheItem)
+		if c.data[item.key] != e {
+			t.Fatalf("缓存项[%s]不在索引中", item.key)
+		}
+		size += int64(computeSize(len(item.key), len(item.data)))
+	}
+	if len(c.data) != c.lru.Len() {
+		t.Fatalf("索引数量[%d]与链表长度[%d]不一致", len(c.data), c.lru.Len())
+	}
+	if size != c.useSize {
+		t.Fatalf("已用空间统计错误, 预期[%d], 实际[%d]", size, c.useSize)
+	}
+	if c.useSize > c.maxSize {
+		t.Fatalf("已用空间[%d]超出上限[%d]", c.useSize, c.maxSize)
+	}
+}
+
+func TestCacheLRU(t *testing.T) {
+	data := make([]byte, 100)
+	itemSize := int64(computeSize(1, len(data)))
+	c := newTestCache(itemSize * 3)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := c.memWrite(key, data, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//读取a后，b成为最久未使用项
+	if _, _, err := c.read("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.memWrite("d", data, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.read("b"); err == nil {
+		t.Fatal("最久未使用项未被淘汰")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, _, err := c.read(key); err != nil {
+			t.Fatalf("缓存项[%s]被错误淘汰", key)
+		}
+	}
+	checkAccounting(t, c)
+}
+
+func TestCacheOverwrite(t *testing.T) {
+	c := newTestCache(1024)
+	if err := c.memWrite("a", make([]byte, 100), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.memWrite("a", make([]byte, 200), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := c.read("a")
+	if err != nil || len(data) != 200 {
+		t.Fatalf("覆盖写入失败 %d %v", len(data), err)
+	}
+	checkAccounting(t, c)
+}
+
+func TestCacheTooLarge(t *testing.T) {
+	c := newTestCache(100)
+	if err := c.memWrite("a", make([]byte, 200), time.Time{}); err == nil {
+		t.Fatal("超出缓存上限未报错")
+	}
+	checkAccounting(t, c)
+}
+
+func TestCacheRemovePrefix(t *testing.T) {
+	c := newTestCache(1024)
+	for _, key := range []string{"abc", "abc1.jpg", "abc1.jpg200_100", "abd"} {
+		if err := c.memWrite(key, []byte(key), time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.removePrefix("abc")
+	for _, key := range []string{"abc", "abc1.jpg", "abc1.jpg200_100"} {
+		if _, _, err := c.read(key); err == nil {
+			t.Fatalf("缓存项[%s]未被删除", key)
+		}
+	}
+	if _, _, err := c.read("abd"); err != nil {
+		t.Fatal("缓存项[abd]被错误删除")
+	}
+	checkAccounting(t, c)
+}
+
+//需配合 go test -race 运行以发现数据竞争
+func TestCacheConcurrent(t *testing.T) {
+	const goroutines = 8
+	const rounds = 1000
+	c := newTestCache(int64(computeSize(8, 64)) * 20)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				key := strconv.Itoa((g*rounds + i) % 50)
+				switch i % 4 {
+				case 0, 1:
+					c.memWrite(key, make([]byte, 64), time.Time{})
+				case 2:
+					c.read(key)
+				case 3:
+					c.removePrefix(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	checkAccounting(t, c)
+}
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+//ErrNotFound 文件不存在，与os.ErrNotExist相同，本地存储的路径错误也可用errors.Is识别
+var ErrNotFound = os.ErrNotExist
+
+//ErrTooLarge 文件超出大小或像素数限制
+var ErrTooLarge = errors.New("文件超出大小限制")
+
+//ErrBadOption 图像处理参数错误
+var ErrBadOption = errors.New("图像处理参数错误")
+
+//ErrBackend 远程存储服务出错或不可用
+var ErrBackend = errors.New("存储服务不可用")
+
+//将远程服务的非200回复转换为对应的错误
+func statusError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusRequestEntityTooLarge:
+		return ErrTooLarge
+	case http.StatusUnsupportedMediaType:
+		return ErrBadFormat
+	}
+	return fmt.Errorf("%w：%s", ErrBackend, resp.Status)
+}
+
+//远程请求失败，如连接被拒绝或超时
+func backendError(err error) error {
+	return fmt.Errorf("%w：%v", ErrBackend, err)
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+
+	"github.com/DDHax/sis/store/graphics"
+	"github.com/DDHax/sis/store/graphics/interp"
+)
+
+//exif中Orientation标签
+const exifOrientationTag = 0x0112
+
+//读取jpeg中APP1段的exif方向，取值1到8，没有或无法解析时返回1
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+
+	//逐段查找APP1，遇到图像数据即停止
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return 1
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xff:
+			//填充字节
+			i++
+			continue
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			//无长度的标记
+			i += 2
+			continue
+		case marker == 0xd9 || marker == 0xda:
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+//解析TIFF结构中第0个IFD的Orientation标签
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) != exifOrientationTag {
+			continue
+		}
+
+		//类型须为SHORT，值存放在值域的前两个字节
+		if order.Uint16(tiff[entry+2:]) != 3 {
+			return 1
+		}
+		o := int(order.Uint16(tiff[entry+8:]))
+		if o < 1 || o > 8 {
+			return 1
+		}
+		return o
+	}
+	return 1
+}
+
+//按exif方向摆正图像，返回原图或新建的图像
+func orient(img image.Image, orientation int) (image.Image, error) {
+	//各方向对应的顺时针旋转角度及旋转前是否水平翻转
+	var angle float64
+	var flip bool
+	switch orientation {
+	case 2:
+		flip = true
+	case 3:
+		angle = math.Pi
+	case 4:
+		angle, flip = math.Pi, true
+	case 5:
+		angle, flip = math.Pi/2, true
+	case 6:
+		angle = math.Pi / 2
+	case 7:
+		angle, flip = -math.Pi/2, true
+	case 8:
+		angle = -math.Pi / 2
+	default:
+		return img, nil
+	}
+
+	//旋转90度时宽高互换
+	b := img.Bounds()
+	dstb := image.Rect(0, 0, b.Dx(), b.Dy())
+	if orientation >= 5 {
+		dstb = image.Rect(0, 0, b.Dy(), b.Dx())
+	}
+	dst := image.NewRGBA(dstb)
+
+	//像素中心经旋转后仍落在像素中心，最近邻插值即可无损
+	a := graphics.I.Rotate(angle)
+	if flip {
+		a = a.Scale(-1, 1)
+	}
+	err := a.CenterFit(dstb, b).Transform(dst, img, interp.NearestNeighbor)
+	return dst, err
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"testing"
+)
+
+//摆正后48x32，左上红、右上绿、左下蓝、右下白
+var orientationQuads = []struct {
+	x, y int
+	want color.RGBA
+}{
+	{12, 8, color.RGBA{0xff, 0, 0, 0xff}},
+	{36, 8, color.RGBA{0, 0xff, 0, 0xff}},
+	{12, 24, color.RGBA{0, 0, 0xff, 0xff}},
+	{36, 24, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+}
+
+func readOrientation(t *testing.T, o int) []byte {
+	data, err := os.ReadFile(fmt.Sprintf("testdata/orientation_%d.jpg", o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func near(c color.Color, want color.RGBA) bool {
+	r, g, b, _ := c.RGBA()
+	d := func(v uint32, w uint8) bool {
+		diff := int(v>>8) - int(w)
+		return diff > -24 && diff < 24
+	}
+	return d(r, want.R) && d(g, want.G) && d(b, want.B)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	for o := 1; o <= 8; o++ {
+		if got := jpegOrientation(readOrientation(t, o)); got != o {
+			t.Errorf("方向解析错误, 预期[%d], 实际[%d]", o, got)
+		}
+	}
+
+	//没有exif或数据残缺时视为正常方向
+	for _, data := range [][]byte{nil, []byte("GIF89a"), readOrientation(t, 6)[:20]} {
+		if got := jpegOrientation(data); got != 1 {
+			t.Errorf("异常数据方向应为1, 实际[%d]", got)
+		}
+	}
+}
+
+func TestAutoRotate(t *testing.T) {
+	for o := 1; o <= 8; o++ {
+		for _, opt := range []Option{{}, {Width: 24}} {
+			data, err := processImage(readOrientation(t, o), opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			//缩放以摆正后的宽高为准
+			s := 1
+			if opt.Width > 0 {
+				s = 2
+			}
+			if b := img.Bounds(); b != image.Rect(0, 0, 48/s, 32/s) {
+				t.Errorf("方向%d尺寸错误 %v", o, b)
+				continue
+			}
+			for _, q := range orientationQuads {
+				if c := img.At(q.x/s, q.y/s); !near(c, q.want) {
+					t.Errorf("方向%d(%d, %d)颜色错误, 预期[%v], 实际[%v]", o, q.x/s, q.y/s, q.want, c)
+				}
+			}
+		}
+	}
+}
+
+func TestNoRotate(t *testing.T) {
+	src := readOrientation(t, 6)
+	data, err := processImage(src, Option{NoRotate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, src) {
+		t.Error("关闭自动旋转时应返回原图")
+	}
+}
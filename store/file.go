@@ -0,0 +1,96 @@
+package store
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+//读取原图时预读的文件头长度，足以容纳jpeg的exif段
+const headSize = 128 * 1024
+
+//File 读取结果，支持Seek以便http.ServeContent处理Range，用完须关闭
+type File struct {
+	io.ReadSeeker
+	Size    int64     //文件大小
+	ModTime time.Time //修改时间，未知时为零值
+	ETag    string    //强校验ETag，含引号，由md5及处理参数确定
+	data    []byte    //内存中的内容，来自缓存或处理结果
+	closer  io.Closer
+}
+
+//内存中的数据，如缓存及处理结果
+func bytesFile(data []byte, modTime time.Time) *File {
+	return &File{
+		ReadSeeker: bytes.NewReader(data),
+		Size:       int64(len(data)),
+		ModTime:    modTime,
+		data:       data,
+	}
+}
+
+//是否为32位16进制的md5
+func validMD5(md5Code string) bool {
+	if len(md5Code) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(md5Code)
+	return err == nil
+}
+
+//由md5及处理参数生成ETag，md5格式不对时返回空串
+func etag(md5Code string, opt Option) string {
+	if !validMD5(md5Code) {
+		return ""
+	}
+	return `"` + md5Code + opt.key() + `"`
+}
+
+//Close 释放文件句柄或网络连接
+func (f *File) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	return f.closer.Close()
+}
+
+//Head 读取前n个字节，不改变读取位置
+func (f *File) Head(n int) []byte {
+	if f.data != nil {
+		if n > len(f.data) {
+			n = len(f.data)
+		}
+		return f.data[:n]
+	}
+
+	buf := make([]byte, n)
+	switch r := f.ReadSeeker.(type) {
+	case io.ReaderAt:
+		n, _ = r.ReadAt(buf, 0)
+	case interface{ Peek(int) ([]byte, error) }:
+		b, _ := r.Peek(n)
+		return b
+	default:
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil
+		}
+		r.Seek(0, io.SeekStart)
+		n, _ = io.ReadFull(r, buf)
+		r.Seek(pos, io.SeekStart)
+	}
+	return buf[:n]
+}
+
+//读出全部内容
+func (f *File) bytes() ([]byte, error) {
+	if f.data != nil {
+		return f.data, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(f)
+}
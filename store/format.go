@@ -0,0 +1,114 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"mime/multipart"
+	"strings"
+)
+
+//ErrBadFormat 文件内容不是可识别的图像
+var ErrBadFormat = errors.New("不是有效的图像文件")
+
+//ErrFormatNotAllowed 图像格式不在允许列表中
+var ErrFormatNotAllowed = errors.New("不允许上传该格式的图像")
+
+//各格式的文件头
+var magics = []struct {
+	format string
+	magic  []byte
+}{
+	{"jpeg", []byte("\xff\xd8\xff")},
+	{"png", []byte("\x89PNG\r\n\x1a\n")},
+	{"gif", []byte("GIF87a")},
+	{"gif", []byte("GIF89a")},
+}
+
+//允许上传的格式，为nil时允许全部可识别的格式
+var allowedFormats map[string]bool
+
+//SetFormats 设置允许上传的格式，取值为jpeg、png、gif，为空时允许全部
+func SetFormats(formats []string) error {
+	allowed := make(map[string]bool)
+	for _, f := range formats {
+		f = strings.TrimSpace(f)
+		switch f {
+		case "":
+			continue
+		case "jpg":
+			f = "jpeg"
+		}
+		if !knownFormat(f) {
+			return errors.New("未知的图像格式：" + f)
+		}
+		allowed[f] = true
+	}
+	if len(allowed) == 0 {
+		allowed = nil
+	}
+	allowedFormats = allowed
+	return nil
+}
+
+func knownFormat(format string) bool {
+	for _, m := range magics {
+		if m.format == format {
+			return true
+		}
+	}
+	return false
+}
+
+//按文件头判断格式，无法识别时返回空字符串
+func sniff(head []byte) string {
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.format
+		}
+	}
+	return ""
+}
+
+//上传前校验内容：文件头、完整的图像头信息、格式白名单及像素数
+func checkUpload(f multipart.File) error {
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+	head := make([]byte, 8)
+	n, _ := io.ReadFull(f, head)
+	format := sniff(head[:n])
+	if format == "" {
+		return ErrBadFormat
+	}
+
+	//文件头与解码结果须一致，防止文件头伪装
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+	config, decoded, err := image.DecodeConfig(f)
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+	if err != nil || decoded != format || config.Width <= 0 || config.Height <= 0 {
+		return ErrBadFormat
+	}
+
+	if allowedFormats != nil && !allowedFormats[format] {
+		return ErrFormatNotAllowed
+	}
+	if overLimit(config.Width, config.Height, maxSrcPixels) {
+		return ErrTooManyPixels
+	}
+
+	//gif按帧数计算像素总数
+	if format == "gif" {
+		err := checkGIF(f)
+		if _, serr := f.Seek(0, 0); serr != nil {
+			return serr
+		}
+		return err
+	}
+	return nil
+}
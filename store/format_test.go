@@ -0,0 +1,58 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//写入临时文件并打开，作为上传的文件
+func openTemp(t *testing.T, data []byte) *os.File {
+	path := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestCheckUploadFormat(t *testing.T) {
+	jpg := readOrientation(t, 1)
+	gif := newTestGIF(t)
+	tests := []struct {
+		data []byte
+		err  error
+	}{
+		{jpg, nil},
+		{gif, nil},
+		{[]byte("<html><body>hello</body></html>"), ErrBadFormat},
+		{nil, ErrBadFormat},
+		//文件头正确但内容残缺
+		{jpg[:4], ErrBadFormat},
+		//png文件头后跟jpeg内容
+		{append([]byte("\x89PNG\r\n\x1a\n"), jpg...), ErrBadFormat},
+	}
+	for i, test := range tests {
+		if err := checkUpload(openTemp(t, test.data)); err != test.err {
+			t.Errorf("%d: 预期[%v], 实际[%v]", i, test.err, err)
+		}
+	}
+
+	if err := SetFormats([]string{"png", " jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetFormats(nil)
+	if err := checkUpload(openTemp(t, jpg)); err != nil {
+		t.Error(err)
+	}
+	if err := checkUpload(openTemp(t, gif)); err != ErrFormatNotAllowed {
+		t.Errorf("预期[%v], 实际[%v]", ErrFormatNotAllowed, err)
+	}
+	if err := SetFormats([]string{"bmp"}); err == nil {
+		t.Error("未知格式应返回错误")
+	}
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+)
+
+//逐帧变换缩放gif，保留帧数、延时和循环次数
+func scaleGIF(data []byte, opt Option) ([]byte, error) {
+	//解码全部帧之前检查帧数
+	if err := checkGIF(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	//逻辑屏幕，各帧按偏移绘制在其上
+	screen := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if screen.Empty() {
+		for _, frame := range g.Image {
+			screen = screen.Union(frame.Bounds())
+		}
+	}
+	canvas := image.NewRGBA(screen)
+
+	out := &gif.GIF{LoopCount: g.LoopCount}
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		//DisposalPrevious需在显示后恢复到绘制前的画面
+		var saved *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			saved = image.NewRGBA(screen)
+			copy(saved.Pix, canvas.Pix)
+		}
+
+		//合成当前帧的完整画面后变换缩放
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		scaled, err := transform(canvas, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		//量化回当前帧的调色板
+		dst := image.NewPaletted(scaled.Bounds(), transparentPalette(frame.Palette))
+		draw.Draw(dst, dst.Bounds(), scaled, scaled.Bounds().Min, draw.Src)
+
+		//输出帧均为完整画面，显示后清空以免透明处透出上一帧
+		out.Image = append(out.Image, dst)
+		out.Disposal = append(out.Disposal, gif.DisposalBackground)
+		if i < len(g.Delay) {
+			out.Delay = append(out.Delay, g.Delay[i])
+		} else {
+			out.Delay = append(out.Delay, 0)
+		}
+
+		//按原始处置方式更新画布
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = saved
+		}
+	}
+
+	var buf bytes.Buffer
+	err = gif.EncodeAll(&buf, out)
+	return buf.Bytes(), err
+}
+
+//确保调色板中有透明色，合成画面中未被任何帧覆盖的区域是透明的
+func transparentPalette(p color.Palette) color.Palette {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return p
+		}
+	}
+
+	pal := make(color.Palette, len(p), len(p)+1)
+	copy(pal, p)
+	if len(pal) < 256 {
+		return append(pal, color.RGBA{})
+	}
+	pal[len(pal)-1] = color.RGBA{}
+	return pal
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+var testPalette = color.Palette{
+	color.RGBA{0xff, 0, 0, 0xff},
+	color.RGBA{0, 0xff, 0, 0xff},
+	color.RGBA{0, 0, 0xff, 0xff},
+	color.RGBA{},
+}
+
+func newFrame(r image.Rectangle, index uint8) *image.Paletted {
+	frame := image.NewPaletted(r, testPalette)
+	for i := range frame.Pix {
+		frame.Pix[i] = index
+	}
+	return frame
+}
+
+//三帧动图：红色全屏；右半绿色且显示后清除；左上角蓝色
+func newTestGIF(t *testing.T) []byte {
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			newFrame(image.Rect(0, 0, 40, 20), 0),
+			newFrame(image.Rect(20, 0, 40, 20), 1),
+			newFrame(image.Rect(0, 0, 10, 10), 2),
+		},
+		Delay:     []int{10, 20, 30},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalNone},
+		LoopCount: 3,
+		Config:    image.Config{Width: 40, Height: 20},
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestScaleGIF(t *testing.T) {
+	data, err := processImage(newTestGIF(t), Option{Width: 20, Height: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Image) != 3 {
+		t.Fatalf("帧数错误, 预期[3], 实际[%d]", len(g.Image))
+	}
+	if g.LoopCount != 3 {
+		t.Errorf("循环次数错误, 预期[3], 实际[%d]", g.LoopCount)
+	}
+	for i, delay := range []int{10, 20, 30} {
+		if g.Delay[i] != delay {
+			t.Errorf("第%d帧延时错误, 预期[%d], 实际[%d]", i, delay, g.Delay[i])
+		}
+		if b := g.Image[i].Bounds(); b != image.Rect(0, 0, 20, 10) {
+			t.Errorf("第%d帧尺寸错误 %v", i, b)
+		}
+	}
+
+	tests := []struct {
+		frame, x, y int
+		want        color.Color
+	}{
+		{0, 15, 5, testPalette[0]},
+		{1, 5, 5, testPalette[0]},
+		{1, 15, 5, testPalette[1]},
+		{2, 2, 2, testPalette[2]},
+		//第二帧显示后被清除，对应区域透明
+		{2, 15, 5, testPalette[3]},
+		{2, 7, 7, testPalette[0]},
+	}
+	for _, test := range tests {
+		got := g.Image[test.frame].At(test.x, test.y)
+		r0, g0, b0, a0 := got.RGBA()
+		r1, g1, b1, a1 := test.want.RGBA()
+		if r0 != r1 || g0 != g1 || b0 != b1 || a0 != a1 {
+			t.Errorf("第%d帧(%d, %d)颜色错误, 预期[%v], 实际[%v]", test.frame, test.x, test.y, test.want, got)
+		}
+	}
+}
+
+func TestTransparentPalette(t *testing.T) {
+	opaque := color.Palette{color.Black, color.White}
+	if p := transparentPalette(opaque); len(p) != 3 || len(opaque) != 2 {
+		t.Errorf("未追加透明色 %v", p)
+	}
+	if p := transparentPalette(testPalette); len(p) != len(testPalette) {
+		t.Errorf("已有透明色时不应修改调色板 %v", p)
+	}
+
+	full := make(color.Palette, 256)
+	for i := range full {
+		full[i] = color.Gray{uint8(i)}
+	}
+	p := transparentPalette(full)
+	if _, _, _, a := p[255].RGBA(); len(p) != 256 || a != 0 {
+		t.Errorf("满调色板未替换透明色 %v", p[255])
+	}
+	if full[255] != (color.Gray{255}) {
+		t.Error("原调色板被修改")
+	}
+}
@@ -7,6 +7,7 @@ package graphics
 import (
 	"errors"
 	"image"
+	"image/color"
 	"image/draw"
 	"math"
 
@@ -39,26 +40,78 @@ func (a Affine) Mul(b Affine) Affine {
 	}
 }
 
-func (a Affine) transformRGBA(dst *image.RGBA, src *image.RGBA, i interp.RGBA) error {
-	srcb := src.Bounds()
+// transformFunc produces dst, reading each interpolated source pixel
+// through at. It is shared by the fast paths for the source types that
+// interp can read without going through image.Image.At.
+func (a Affine) transformFunc(dst *image.RGBA, srcb image.Rectangle, at func(sx, sy float64) color.RGBA) {
 	b := dst.Bounds()
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			sx, sy := a.pt(x, y)
-			if inBounds(srcb, sx, sy) {
-				c := i.RGBA(src, sx, sy)
-				off := (y-dst.Rect.Min.Y)*dst.Stride + (x-dst.Rect.Min.X)*4
-				dst.Pix[off+0] = c.R
-				dst.Pix[off+1] = c.G
-				dst.Pix[off+2] = c.B
-				dst.Pix[off+3] = c.A
+	parallelRows(b.Min.Y, b.Max.Y, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				sx, sy := a.pt(x, y)
+				if inBounds(srcb, sx, sy) {
+					c := at(sx, sy)
+					off := (y-dst.Rect.Min.Y)*dst.Stride + (x-dst.Rect.Min.X)*4
+					dst.Pix[off+0] = c.R
+					dst.Pix[off+1] = c.G
+					dst.Pix[off+2] = c.B
+					dst.Pix[off+3] = c.A
+				}
 			}
 		}
+	})
+}
+
+// transformFast applies the affine transform through one of the interp
+// fast paths, if dst, src and i allow it.
+func (a Affine) transformFast(dst draw.Image, src image.Image, i interp.Interp) bool {
+	dstRGBA, ok := dst.(*image.RGBA)
+	if !ok {
+		return false
 	}
-	return nil
+
+	switch src := src.(type) {
+	case *image.RGBA:
+		if i, ok := i.(interp.RGBA); ok {
+			a.transformFunc(dstRGBA, src.Bounds(), func(sx, sy float64) color.RGBA {
+				return i.RGBA(src, sx, sy)
+			})
+			return true
+		}
+	case *image.YCbCr:
+		if i, ok := i.(interp.YCbCr); ok {
+			a.transformFunc(dstRGBA, src.Bounds(), func(sx, sy float64) color.RGBA {
+				return i.YCbCr(src, sx, sy)
+			})
+			return true
+		}
+	case *image.NRGBA:
+		if i, ok := i.(interp.NRGBA); ok {
+			a.transformFunc(dstRGBA, src.Bounds(), func(sx, sy float64) color.RGBA {
+				return i.NRGBA(src, sx, sy)
+			})
+			return true
+		}
+	case *image.RGBA64:
+		if i, ok := i.(interp.RGBA64); ok {
+			a.transformFunc(dstRGBA, src.Bounds(), func(sx, sy float64) color.RGBA {
+				return i.RGBA64(src, sx, sy)
+			})
+			return true
+		}
+	case *image.Paletted:
+		if i, ok := i.(interp.Paletted); ok {
+			a.transformFunc(dstRGBA, src.Bounds(), func(sx, sy float64) color.RGBA {
+				return i.Paletted(src, sx, sy)
+			})
+			return true
+		}
+	}
+	return false
 }
 
 // Transform applies the affine transform to src and produces dst.
+// Bands of rows are transformed concurrently, see SetWorkers.
 func (a Affine) Transform(dst draw.Image, src image.Image, i interp.Interp) error {
 	if dst == nil {
 		return errors.New("graphics: dst is nil")
@@ -67,24 +120,28 @@ func (a Affine) Transform(dst draw.Image, src image.Image, i interp.Interp) erro
 		return errors.New("graphics: src is nil")
 	}
 
-	// RGBA fast path.
-	dstRGBA, dstOk := dst.(*image.RGBA)
-	srcRGBA, srcOk := src.(*image.RGBA)
-	interpRGBA, interpOk := i.(interp.RGBA)
-	if dstOk && srcOk && interpOk {
-		return a.transformRGBA(dstRGBA, srcRGBA, interpRGBA)
+	// RGBA, YCbCr, NRGBA, RGBA64 and Paletted fast paths.
+	if a.transformFast(dst, src, i) {
+		return nil
 	}
 
 	srcb := src.Bounds()
 	b := dst.Bounds()
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			sx, sy := a.pt(x, y)
-			if inBounds(srcb, sx, sy) {
-				dst.Set(x, y, i.Interp(src, sx, sy))
+	rows := func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				sx, sy := a.pt(x, y)
+				if inBounds(srcb, sx, sy) {
+					dst.Set(x, y, i.Interp(src, sx, sy))
+				}
 			}
 		}
 	}
+	if setsIndependently(dst) {
+		parallelRows(b.Min.Y, b.Max.Y, rows)
+	} else {
+		rows(b.Min.Y, b.Max.Y)
+	}
 	return nil
 }
 
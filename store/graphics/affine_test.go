@@ -0,0 +1,222 @@
+// Copyright 2011 The Graphics-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graphics
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/DDHax/sis/store/graphics/interp"
+)
+
+// newNoise returns a w×h image filled with a deterministic pattern.
+func newNoise(w, h int) *image.RGBA {
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	var v uint32 = 1
+	for i := range src.Pix {
+		if i%4 == 3 {
+			src.Pix[i] = 0xff
+			continue
+		}
+		v = v*1664525 + 1013904223
+		src.Pix[i] = uint8(v >> 24)
+	}
+	return src
+}
+
+// withWorkers runs f with the worker pool set to n goroutines.
+func withWorkers(n int, f func()) {
+	old := cap(workerPool())
+	SetWorkers(n)
+	defer SetWorkers(old)
+	f()
+}
+
+func TestTransformParallelIdentical(t *testing.T) {
+	src := newNoise(301, 257)
+	a := I.Rotate(0.3).Scale(0.7, 1.3).CenterFit(image.Rect(0, 0, 211, 333), src.Bounds())
+	tests := []struct {
+		desc string
+		dst  func() draw.Image
+		i    interp.Interp
+	}{
+		{"rgba bilinear", func() draw.Image { return image.NewRGBA(image.Rect(0, 0, 211, 333)) }, interp.Bilinear},
+		{"rgba lanczos3", func() draw.Image { return image.NewRGBA(image.Rect(0, 0, 211, 333)) }, interp.Lanczos3},
+		{"nrgba generic", func() draw.Image { return image.NewNRGBA(image.Rect(0, 0, 211, 333)) }, interp.Bilinear},
+		{"gray generic", func() draw.Image { return image.NewGray(image.Rect(3, 5, 214, 338)) }, interp.CatmullRom},
+	}
+	for _, test := range tests {
+		serial, parallel := test.dst(), test.dst()
+		withWorkers(1, func() {
+			if err := a.Transform(serial, src, test.i); err != nil {
+				t.Fatal(err)
+			}
+		})
+		withWorkers(7, func() {
+			if err := a.Transform(parallel, src, test.i); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if !bytes.Equal(pix(serial), pix(parallel)) {
+			t.Errorf("%s: parallel result differs from serial", test.desc)
+		}
+	}
+}
+
+func pix(m image.Image) []uint8 {
+	switch m := m.(type) {
+	case *image.RGBA:
+		return m.Pix
+	case *image.NRGBA:
+		return m.Pix
+	case *image.Gray:
+		return m.Pix
+	}
+	panic("unexpected image type")
+}
+
+// otherImage is a draw.Image that is not safe to set concurrently.
+type otherImage struct {
+	*image.RGBA
+	sets int
+}
+
+func (m *otherImage) Set(x, y int, c color.Color) {
+	m.sets++
+	m.RGBA.Set(x, y, c)
+}
+
+func TestTransformSerialFallback(t *testing.T) {
+	src := newNoise(64, 64)
+	dst := &otherImage{RGBA: image.NewRGBA(image.Rect(0, 0, 128, 128))}
+	withWorkers(8, func() {
+		if err := I.Scale(2, 2).Transform(dst, src, interp.Bilinear); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if dst.sets != 128*128 {
+		t.Errorf("got %d sets want %d", dst.sets, 128*128)
+	}
+}
+
+func benchmarkScale(b *testing.B, srcW, srcH, workers int) {
+	src := newNoise(srcW, srcH)
+	dst := image.NewRGBA(image.Rect(0, 0, int(math.Round(float64(srcW)*0.6)), int(math.Round(float64(srcH)*0.6))))
+	withWorkers(workers, func() {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := Scale(dst, src, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkScale1080pSerial(b *testing.B)   { benchmarkScale(b, 1920, 1080, 1) }
+func BenchmarkScale1080pParallel(b *testing.B) { benchmarkScale(b, 1920, 1080, runtime.NumCPU()) }
+func BenchmarkScale4KSerial(b *testing.B)      { benchmarkScale(b, 3840, 2160, 1) }
+func BenchmarkScale4KParallel(b *testing.B)    { benchmarkScale(b, 3840, 2160, runtime.NumCPU()) }
+
+// sources returns src converted to every type with an interp fast path.
+func sources(t testing.TB, src *image.RGBA) map[string]image.Image {
+	b := src.Bounds()
+	m := map[string]image.Image{"rgba": src}
+
+	nrgba := image.NewNRGBA(b)
+	draw.Draw(nrgba, b, src, b.Min, draw.Src)
+	m["nrgba"] = nrgba
+
+	rgba64 := image.NewRGBA64(b)
+	draw.Draw(rgba64, b, src, b.Min, draw.Src)
+	m["rgba64"] = rgba64
+
+	paletted := image.NewPaletted(b, palette.Plan9)
+	draw.Draw(paletted, b, src, b.Min, draw.Src)
+	m["paletted"] = paletted
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	ycbcr, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m["ycbcr"] = ycbcr
+	return m
+}
+
+// generic hides the concrete type of an image, forcing the slow path.
+type generic struct{ image.Image }
+
+func TestTransformFastPaths(t *testing.T) {
+	src := newNoise(64, 48)
+	// A translucent area exercises premultiplication.
+	for i := 3; i < len(src.Pix)/2; i += 4 {
+		src.Pix[i] = 0x80
+		src.Pix[i-1] /= 2
+		src.Pix[i-2] /= 2
+		src.Pix[i-3] /= 2
+	}
+	a := I.Rotate(0.2).Scale(1.7, 0.6).CenterFit(image.Rect(0, 0, 50, 70), src.Bounds())
+	for name, s := range sources(t, src) {
+		for _, i := range []interp.Interp{interp.NearestNeighbor, interp.Bilinear, interp.CatmullRom} {
+			fast := image.NewRGBA(image.Rect(0, 0, 50, 70))
+			slow := image.NewRGBA(fast.Bounds())
+			if err := a.Transform(fast, s, i); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Transform(slow, generic{s}, i); err != nil {
+				t.Fatal(err)
+			}
+			for j := range fast.Pix {
+				if d := int(fast.Pix[j]) - int(slow.Pix[j]); d < -1 || d > 1 {
+					t.Errorf("%s %T: byte %d got %d want %d", name, i, j, fast.Pix[j], slow.Pix[j])
+					break
+				}
+			}
+		}
+	}
+}
+
+// loadJPEG decodes a real photo from the test client fixtures.
+func loadJPEG(b *testing.B) image.Image {
+	f, err := os.Open("../../test/client/test1.jpg")
+	if err != nil {
+		b.Skip(err)
+	}
+	defer f.Close()
+	src, err := jpeg.Decode(f)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return src
+}
+
+func benchmarkScaleJPEG(b *testing.B, slow bool) {
+	src := loadJPEG(b)
+	if slow {
+		src = generic{src}
+	}
+	sb := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, sb.Dx()*3/4, sb.Dy()*3/4))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Scale(dst, src, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScaleJPEGFast(b *testing.B)    { benchmarkScaleJPEG(b, false) }
+func BenchmarkScaleJPEGGeneric(b *testing.B) { benchmarkScaleJPEG(b, true) }
@@ -118,6 +118,53 @@ func (bilinear) Gray(src *image.Gray, x, y float64) color.Gray {
 	return c
 }
 
+func (bilinear) YCbCr(src *image.YCbCr, x, y float64) color.RGBA {
+	return bilinearPixels(findLinearSrc(src.Bounds(), x, y), ycbcrPixels(src))
+}
+
+func (bilinear) NRGBA(src *image.NRGBA, x, y float64) color.RGBA {
+	return bilinearPixels(findLinearSrc(src.Bounds(), x, y), nrgbaPixels(src))
+}
+
+func (bilinear) RGBA64(src *image.RGBA64, x, y float64) color.RGBA {
+	return bilinearPixels(findLinearSrc(src.Bounds(), x, y), rgba64Pixels(src))
+}
+
+func (bilinear) Paletted(src *image.Paletted, x, y float64) color.RGBA {
+	return bilinearPixels(findLinearSrc(src.Bounds(), x, y), palettedPixels(src))
+}
+
+// bilinearPixels blends the four pixels of p read through at.
+func bilinearPixels(p bilinearSrc, at pixelFunc) color.RGBA {
+	var fr, fg, fb, fa float64
+
+	r, g, b, a := at(p.low.X, p.low.Y)
+	fr += r * p.frac00
+	fg += g * p.frac00
+	fb += b * p.frac00
+	fa += a * p.frac00
+
+	r, g, b, a = at(p.high.X, p.low.Y)
+	fr += r * p.frac01
+	fg += g * p.frac01
+	fb += b * p.frac01
+	fa += a * p.frac01
+
+	r, g, b, a = at(p.low.X, p.high.Y)
+	fr += r * p.frac10
+	fg += g * p.frac10
+	fb += b * p.frac10
+	fa += a * p.frac10
+
+	r, g, b, a = at(p.high.X, p.high.Y)
+	fr += r * p.frac11
+	fg += g * p.frac11
+	fb += b * p.frac11
+	fa += a * p.frac11
+
+	return premultiplied(fr, fg, fb, fa)
+}
+
 type bilinearSrc struct {
 	// Top-left and bottom-right interpolation sources
 	low, high image.Point
@@ -27,3 +27,35 @@ type Gray interface {
 	// Gray interpolates (x, y).
 	Gray(src *image.Gray, x, y float64) color.Gray
 }
+
+// YCbCr is a fast-path interpolation implementation for image.YCbCr,
+// the usual result of decoding a JPEG. The result is suitable for an
+// image.RGBA destination.
+type YCbCr interface {
+	// YCbCr interpolates (x, y).
+	YCbCr(src *image.YCbCr, x, y float64) color.RGBA
+}
+
+// NRGBA is a fast-path interpolation implementation for image.NRGBA.
+// The result is alpha-premultiplied, suitable for an image.RGBA
+// destination.
+type NRGBA interface {
+	// NRGBA interpolates (x, y).
+	NRGBA(src *image.NRGBA, x, y float64) color.RGBA
+}
+
+// RGBA64 is a fast-path interpolation implementation for image.RGBA64.
+// The result is reduced to 8 bits per channel, suitable for an image.RGBA
+// destination.
+type RGBA64 interface {
+	// RGBA64 interpolates (x, y).
+	RGBA64(src *image.RGBA64, x, y float64) color.RGBA
+}
+
+// Paletted is a fast-path interpolation implementation for image.Paletted,
+// the usual result of decoding a GIF. The result is suitable for an
+// image.RGBA destination.
+type Paletted interface {
+	// Paletted interpolates (x, y).
+	Paletted(src *image.Paletted, x, y float64) color.RGBA
+}
@@ -0,0 +1,234 @@
+// Copyright 2012 The Graphics-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package interp
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Bicubic implements bicubic interpolation. It is an alias of CatmullRom.
+var Bicubic = CatmullRom
+
+// CatmullRom implements bicubic interpolation with the Catmull-Rom spline
+// (B=0, C=1/2). It is sharp and reproduces the source at pixel centers.
+var CatmullRom Interp = &kernel{2, bcSpline(0, 0.5)}
+
+// MitchellNetravali implements bicubic interpolation with the
+// Mitchell-Netravali filter (B=1/3, C=1/3). It rings less than
+// CatmullRom at the cost of some sharpness.
+var MitchellNetravali Interp = &kernel{2, bcSpline(1.0/3, 1.0/3)}
+
+// Lanczos3 implements interpolation with the three-lobed Lanczos window.
+var Lanczos3 Interp = &kernel{3, lanczos(3)}
+
+// maxTaps is the largest number of source pixels a kernel reads along
+// one axis. It must be at least twice the largest support.
+const maxTaps = 6
+
+// kernel is a separable interpolation filter with finite support.
+type kernel struct {
+	// support is the radius of the filter, in source pixels.
+	support float64
+	// at evaluates the filter at a distance t from the sample point.
+	at func(t float64) float64
+}
+
+// kernelTaps holds the source pixels and normalized weights that
+// contribute to a sample along one axis.
+type kernelTaps struct {
+	n   int
+	idx [maxTaps]int
+	w   [maxTaps]float64
+}
+
+// taps finds the contributions along one axis for the co-ordinate c.
+// Pixels outside [min, max) are clamped to the nearest edge pixel.
+func (k *kernel) taps(c float64, min, max int) (t kernelTaps) {
+	// Pixel centers lie at half-integer co-ordinates.
+	c -= 0.5
+	first := int(math.Floor(c-k.support)) + 1
+	last := int(math.Floor(c + k.support))
+
+	var sum float64
+	for i := first; i <= last && t.n < maxTaps; i++ {
+		w := k.at(c - float64(i))
+		if w == 0 {
+			continue
+		}
+		t.idx[t.n] = clamp(i, min, max-1)
+		t.w[t.n] = w
+		sum += w
+		t.n++
+	}
+	for i := 0; i < t.n; i++ {
+		t.w[i] /= sum
+	}
+	return t
+}
+
+func (k *kernel) Interp(src image.Image, x, y float64) color.Color {
+	if src, ok := src.(*image.RGBA); ok {
+		return k.RGBA(src, x, y)
+	}
+
+	b := src.Bounds()
+	tx := k.taps(x, b.Min.X, b.Max.X)
+	ty := k.taps(y, b.Min.Y, b.Max.Y)
+
+	var fr, fg, fb, fa float64
+	for j := 0; j < ty.n; j++ {
+		var rr, rg, rb, ra float64
+		for i := 0; i < tx.n; i++ {
+			r, g, b, a := src.At(tx.idx[i], ty.idx[j]).RGBA()
+			w := tx.w[i]
+			rr += float64(r) * w
+			rg += float64(g) * w
+			rb += float64(b) * w
+			ra += float64(a) * w
+		}
+		w := ty.w[j]
+		fr += rr * w
+		fg += rg * w
+		fb += rb * w
+		fa += ra * w
+	}
+
+	// Negative lobes may overshoot; keep the result a valid
+	// alpha-premultiplied color.
+	a := clampRound(fa, 0xffff)
+	return color.RGBA64{
+		R: uint16(clampRound(fr, a)),
+		G: uint16(clampRound(fg, a)),
+		B: uint16(clampRound(fb, a)),
+		A: uint16(a),
+	}
+}
+
+func (k *kernel) RGBA(src *image.RGBA, x, y float64) color.RGBA {
+	b := src.Bounds()
+	tx := k.taps(x, b.Min.X, b.Max.X)
+	ty := k.taps(y, b.Min.Y, b.Max.Y)
+
+	var fr, fg, fb, fa float64
+	for j := 0; j < ty.n; j++ {
+		var rr, rg, rb, ra float64
+		for i := 0; i < tx.n; i++ {
+			off := offRGBA(src, tx.idx[i], ty.idx[j])
+			w := tx.w[i]
+			rr += float64(src.Pix[off+0]) * w
+			rg += float64(src.Pix[off+1]) * w
+			rb += float64(src.Pix[off+2]) * w
+			ra += float64(src.Pix[off+3]) * w
+		}
+		w := ty.w[j]
+		fr += rr * w
+		fg += rg * w
+		fb += rb * w
+		fa += ra * w
+	}
+
+	return premultiplied(fr, fg, fb, fa)
+}
+
+func (k *kernel) Gray(src *image.Gray, x, y float64) color.Gray {
+	b := src.Bounds()
+	tx := k.taps(x, b.Min.X, b.Max.X)
+	ty := k.taps(y, b.Min.Y, b.Max.Y)
+
+	var fc float64
+	for j := 0; j < ty.n; j++ {
+		var rc float64
+		for i := 0; i < tx.n; i++ {
+			rc += float64(src.Pix[offGray(src, tx.idx[i], ty.idx[j])]) * tx.w[i]
+		}
+		fc += rc * ty.w[j]
+	}
+	return color.Gray{uint8(clampRound(fc, 0xff))}
+}
+
+func (k *kernel) YCbCr(src *image.YCbCr, x, y float64) color.RGBA {
+	return k.pixels(src.Bounds(), x, y, ycbcrPixels(src))
+}
+
+func (k *kernel) NRGBA(src *image.NRGBA, x, y float64) color.RGBA {
+	return k.pixels(src.Bounds(), x, y, nrgbaPixels(src))
+}
+
+func (k *kernel) RGBA64(src *image.RGBA64, x, y float64) color.RGBA {
+	return k.pixels(src.Bounds(), x, y, rgba64Pixels(src))
+}
+
+func (k *kernel) Paletted(src *image.Paletted, x, y float64) color.RGBA {
+	return k.pixels(src.Bounds(), x, y, palettedPixels(src))
+}
+
+// pixels filters the pixels around (x, y) in b, read through at.
+func (k *kernel) pixels(b image.Rectangle, x, y float64, at pixelFunc) color.RGBA {
+	tx := k.taps(x, b.Min.X, b.Max.X)
+	ty := k.taps(y, b.Min.Y, b.Max.Y)
+
+	var fr, fg, fb, fa float64
+	for j := 0; j < ty.n; j++ {
+		var rr, rg, rb, ra float64
+		for i := 0; i < tx.n; i++ {
+			r, g, b, a := at(tx.idx[i], ty.idx[j])
+			w := tx.w[i]
+			rr += r * w
+			rg += g * w
+			rb += b * w
+			ra += a * w
+		}
+		w := ty.w[j]
+		fr += rr * w
+		fg += rg * w
+		fb += rb * w
+		fa += ra * w
+	}
+	return premultiplied(fr, fg, fb, fa)
+}
+
+// clampRound rounds v to the nearest integer in [0, max].
+func clampRound(v float64, max int) int {
+	i := int(v + 0.5)
+	if v < 0 || i < 0 {
+		return 0
+	}
+	if i > max {
+		return max
+	}
+	return i
+}
+
+// bcSpline returns the cubic filter from the Mitchell-Netravali family
+// with parameters b and c.
+func bcSpline(b, c float64) func(float64) float64 {
+	return func(t float64) float64 {
+		t = math.Abs(t)
+		switch {
+		case t < 1:
+			return ((12-9*b-6*c)*t*t*t + (-18+12*b+6*c)*t*t + (6 - 2*b)) / 6
+		case t < 2:
+			return ((-b-6*c)*t*t*t + (6*b+30*c)*t*t + (-12*b-48*c)*t + (8*b + 24*c)) / 6
+		}
+		return 0
+	}
+}
+
+// lanczos returns the Lanczos window with a lobes.
+func lanczos(a float64) func(float64) float64 {
+	return func(t float64) float64 {
+		t = math.Abs(t)
+		switch {
+		case t == 0:
+			return 1
+		case t < a:
+			pt := math.Pi * t
+			return a * math.Sin(pt) * math.Sin(pt/a) / (pt * pt)
+		}
+		return 0
+	}
+}
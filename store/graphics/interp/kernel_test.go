@@ -0,0 +1,116 @@
+// Copyright 2012 The Graphics-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package interp
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+var kernelTests = []struct {
+	name string
+	i    Interp
+	// exact reports whether the interpolator reproduces the source
+	// at pixel centers.
+	exact bool
+}{
+	{"nearest", NearestNeighbor, true},
+	{"catmullrom", CatmullRom, true},
+	{"mitchell", MitchellNetravali, false},
+	{"lanczos3", Lanczos3, true},
+}
+
+func newPattern() *image.RGBA {
+	src := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			v := uint8(x*31 + y*17)
+			src.SetRGBA(x, y, color.RGBA{v, v / 2, 0xff - v, 0xff})
+		}
+	}
+	return src
+}
+
+func TestKernelPixelCenters(t *testing.T) {
+	src := newPattern()
+	for _, k := range kernelTests {
+		if !k.exact {
+			continue
+		}
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				got := k.i.(RGBA).RGBA(src, float64(x)+0.5, float64(y)+0.5)
+				want := src.RGBAAt(x, y)
+				if got != want {
+					t.Errorf("%s: (%d, %d) got %v want %v", k.name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestKernelConstant(t *testing.T) {
+	want := color.RGBA{0x40, 0x80, 0xc0, 0xff}
+	src := image.NewRGBA(image.Rect(0, 0, 5, 5))
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 5; x++ {
+			src.SetRGBA(x, y, want)
+		}
+	}
+	gray := image.NewGray(src.Bounds())
+	for i := range gray.Pix {
+		gray.Pix[i] = 0x80
+	}
+
+	for _, k := range kernelTests {
+		for _, p := range [][2]float64{{0, 0}, {0.3, 4.9}, {2.5, 2.5}, {1.7, 3.2}, {5, 5}} {
+			if got := k.i.(RGBA).RGBA(src, p[0], p[1]); got != want {
+				t.Errorf("%s: RGBA at %v got %v want %v", k.name, p, got, want)
+			}
+			if got := k.i.(Gray).Gray(gray, p[0], p[1]); got.Y != 0x80 {
+				t.Errorf("%s: Gray at %v got %v want 0x80", k.name, p, got)
+			}
+		}
+	}
+}
+
+func TestKernelGeneral(t *testing.T) {
+	src := newPattern()
+	// Wrap src so that Interp cannot use the RGBA fast path.
+	general := struct{ image.Image }{src}
+	for _, k := range kernelTests {
+		for _, p := range [][2]float64{{0.2, 0.7}, {3.3, 4.1}, {7.9, 2.5}} {
+			c := k.i.(RGBA).RGBA(src, p[0], p[1])
+			cGen := color.RGBAModel.Convert(k.i.Interp(general, p[0], p[1])).(color.RGBA)
+			if !near(c, cGen) {
+				t.Errorf("%s: general case at %v got %v want %v", k.name, p, cGen, c)
+			}
+		}
+	}
+}
+
+func TestKernelPremultiplied(t *testing.T) {
+	// A sharp edge between opaque white and transparent black makes the
+	// negative lobes overshoot; the result must stay premultiplied.
+	src := image.NewRGBA(image.Rect(0, 0, 6, 1))
+	for x := 3; x < 6; x++ {
+		src.SetRGBA(x, 0, color.RGBA{0xff, 0xff, 0xff, 0xff})
+	}
+	for _, k := range kernelTests {
+		for x := 0.0; x < 6; x += 0.25 {
+			c := k.i.(RGBA).RGBA(src, x, 0.5)
+			if c.R > c.A || c.G > c.A || c.B > c.A {
+				t.Errorf("%s: at %.2f got non-premultiplied %v", k.name, x, c)
+			}
+		}
+	}
+}
+
+// near reports whether a and b differ by at most one in every channel.
+func near(a, b color.RGBA) bool {
+	d := func(x, y uint8) bool { return x-y <= 1 || y-x <= 1 }
+	return d(a.R, b.R) && d(a.G, b.G) && d(a.B, b.B) && d(a.A, b.A)
+}
@@ -0,0 +1,72 @@
+// Copyright 2012 The Graphics-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package interp
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// NearestNeighbor implements nearest neighbor interpolation.
+var NearestNeighbor Interp = nearest{}
+
+type nearest struct{}
+
+func (i nearest) Interp(src image.Image, x, y float64) color.Color {
+	if src, ok := src.(*image.RGBA); ok {
+		return i.RGBA(src, x, y)
+	}
+	p := findNearestSrc(src.Bounds(), x, y)
+	return src.At(p.X, p.Y)
+}
+
+func (nearest) RGBA(src *image.RGBA, x, y float64) color.RGBA {
+	p := findNearestSrc(src.Bounds(), x, y)
+	off := offRGBA(src, p.X, p.Y)
+	return color.RGBA{src.Pix[off+0], src.Pix[off+1], src.Pix[off+2], src.Pix[off+3]}
+}
+
+func (nearest) Gray(src *image.Gray, x, y float64) color.Gray {
+	p := findNearestSrc(src.Bounds(), x, y)
+	return color.Gray{src.Pix[offGray(src, p.X, p.Y)]}
+}
+
+func (nearest) YCbCr(src *image.YCbCr, x, y float64) color.RGBA {
+	p := findNearestSrc(src.Bounds(), x, y)
+	return premultiplied(ycbcrPixels(src)(p.X, p.Y))
+}
+
+func (nearest) NRGBA(src *image.NRGBA, x, y float64) color.RGBA {
+	p := findNearestSrc(src.Bounds(), x, y)
+	return premultiplied(nrgbaPixels(src)(p.X, p.Y))
+}
+
+func (nearest) RGBA64(src *image.RGBA64, x, y float64) color.RGBA {
+	p := findNearestSrc(src.Bounds(), x, y)
+	return premultiplied(rgba64Pixels(src)(p.X, p.Y))
+}
+
+func (nearest) Paletted(src *image.Paletted, x, y float64) color.RGBA {
+	p := findNearestSrc(src.Bounds(), x, y)
+	return premultiplied(palettedPixels(src)(p.X, p.Y))
+}
+
+// findNearestSrc returns the pixel whose area contains (sx, sy),
+// clamped to b.
+func findNearestSrc(b image.Rectangle, sx, sy float64) image.Point {
+	return image.Pt(clamp(int(math.Floor(sx)), b.Min.X, b.Max.X-1),
+		clamp(int(math.Floor(sy)), b.Min.Y, b.Max.Y-1))
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
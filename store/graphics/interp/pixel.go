@@ -0,0 +1,69 @@
+// Copyright 2012 The Graphics-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package interp
+
+import (
+	"image"
+	"image/color"
+)
+
+// pixelFunc returns the alpha-premultiplied color of the pixel at (x, y),
+// scaled to 8 bits per channel. It lets one interpolation routine read
+// every concrete image type without going through image.Image.At.
+type pixelFunc func(x, y int) (r, g, b, a float64)
+
+func ycbcrPixels(src *image.YCbCr) pixelFunc {
+	return func(x, y int) (float64, float64, float64, float64) {
+		yi := src.YOffset(x, y)
+		ci := src.COffset(x, y)
+		r, g, b := color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+		return float64(r), float64(g), float64(b), 0xff
+	}
+}
+
+func nrgbaPixels(src *image.NRGBA) pixelFunc {
+	return func(x, y int) (float64, float64, float64, float64) {
+		off := (y-src.Rect.Min.Y)*src.Stride + (x-src.Rect.Min.X)*4
+		a := float64(src.Pix[off+3])
+		return float64(src.Pix[off+0]) * a / 0xff,
+			float64(src.Pix[off+1]) * a / 0xff,
+			float64(src.Pix[off+2]) * a / 0xff,
+			a
+	}
+}
+
+func rgba64Pixels(src *image.RGBA64) pixelFunc {
+	return func(x, y int) (float64, float64, float64, float64) {
+		off := (y-src.Rect.Min.Y)*src.Stride + (x-src.Rect.Min.X)*8
+		s := src.Pix[off : off+8 : off+8]
+		return float64(uint16(s[0])<<8|uint16(s[1])) / 0x101,
+			float64(uint16(s[2])<<8|uint16(s[3])) / 0x101,
+			float64(uint16(s[4])<<8|uint16(s[5])) / 0x101,
+			float64(uint16(s[6])<<8|uint16(s[7])) / 0x101
+	}
+}
+
+func palettedPixels(src *image.Paletted) pixelFunc {
+	return func(x, y int) (float64, float64, float64, float64) {
+		i := src.Pix[(y-src.Rect.Min.Y)*src.Stride+(x-src.Rect.Min.X)]
+		if int(i) >= len(src.Palette) {
+			return 0, 0, 0, 0
+		}
+		r, g, b, a := src.Palette[i].RGBA()
+		return float64(r) / 0x101, float64(g) / 0x101, float64(b) / 0x101, float64(a) / 0x101
+	}
+}
+
+// premultiplied rounds the channel sums to a valid alpha-premultiplied
+// color.
+func premultiplied(r, g, b, a float64) color.RGBA {
+	ai := clampRound(a, 0xff)
+	return color.RGBA{
+		R: uint8(clampRound(r, ai)),
+		G: uint8(clampRound(g, ai)),
+		B: uint8(clampRound(b, ai)),
+		A: uint8(ai),
+	}
+}
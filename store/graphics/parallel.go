@@ -0,0 +1,85 @@
+// Copyright 2011 The Graphics-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graphics
+
+import (
+	"image"
+	"runtime"
+	"sync"
+)
+
+// minBandRows is the smallest band of rows worth handing to a worker.
+const minBandRows = 16
+
+var (
+	poolMu sync.Mutex
+	// pool bounds the goroutines transforming row bands. It is shared by
+	// all concurrent transforms, so that many simultaneous resizes do not
+	// multiply the number of busy goroutines.
+	pool = make(chan struct{}, runtime.NumCPU())
+)
+
+// SetWorkers sets the maximum number of goroutines that transforms use
+// in total. An n of 1 or less makes every transform run serially on the
+// calling goroutine. The default is the number of CPUs.
+func SetWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	poolMu.Lock()
+	pool = make(chan struct{}, n)
+	poolMu.Unlock()
+}
+
+func workerPool() chan struct{} {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	return pool
+}
+
+// parallelRows calls f for consecutive bands of rows covering [minY, maxY)
+// and waits for all of them. Bands run concurrently on the worker pool, so
+// f must only write to the rows it is given. Small ranges run serially.
+func parallelRows(minY, maxY int, f func(y0, y1 int)) {
+	sem := workerPool()
+	bands := (maxY - minY) / minBandRows
+	if bands > cap(sem) {
+		bands = cap(sem)
+	}
+	if bands <= 1 {
+		f(minY, maxY)
+		return
+	}
+
+	var wg sync.WaitGroup
+	rows := maxY - minY
+	for i := 0; i < bands; i++ {
+		y0 := minY + rows*i/bands
+		y1 := minY + rows*(i+1)/bands
+		wg.Add(1)
+		go func() {
+			sem <- struct{}{}
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			f(y0, y1)
+		}()
+	}
+	wg.Wait()
+}
+
+// setsIndependently reports whether concurrent calls to dst.Set on
+// different rows are safe. This holds for the standard library images,
+// which keep every row in its own part of Pix.
+func setsIndependently(dst image.Image) bool {
+	switch dst.(type) {
+	case *image.RGBA, *image.RGBA64, *image.NRGBA, *image.NRGBA64,
+		*image.Gray, *image.Gray16, *image.Alpha, *image.Alpha16,
+		*image.CMYK, *image.Paletted:
+		return true
+	}
+	return false
+}
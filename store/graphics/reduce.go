@@ -0,0 +1,112 @@
+// Copyright 2011 The Graphics-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graphics
+
+import (
+	"image"
+	"image/draw"
+)
+
+// reduce builds a mipmap pyramid of src, halving it along each axis while
+// the scale factor on that axis is below 0.5. Interpolators read at most a
+// few neighbouring pixels, so without this most of the source would be
+// skipped by large reductions. reduce returns src itself when no halving
+// is needed.
+func reduce(src image.Image, sx, sy float64) image.Image {
+	for sx < 0.5 || sy < 0.5 {
+		hx, hy := sx < 0.5, sy < 0.5
+		src = halve(src, hx, hy)
+		if hx {
+			sx *= 2
+		}
+		if hy {
+			sy *= 2
+		}
+	}
+	return src
+}
+
+// halve returns src reduced by a factor of two along the axes selected by
+// hx and hy. Each destination pixel is the average of a full block of
+// source pixels; the block for a trailing odd row or column is shifted back
+// by one so that it blends with its neighbour instead of standing alone.
+func halve(src image.Image, hx, hy bool) *image.RGBA {
+	sb := src.Bounds()
+	w, h := sb.Dx(), sb.Dy()
+	stepX, stepY := 1, 1
+	if hx {
+		w, stepX = (w+1)/2, 2
+	}
+	if hy {
+		h, stepY = (h+1)/2, 2
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	if src, ok := src.(*image.RGBA); ok {
+		halveRGBA(dst, src, stepX, stepY)
+		return dst
+	}
+
+	// Other images are converted one band of rows at a time, which uses
+	// the fast paths of image/draw without copying the whole source.
+	band := image.NewRGBA(image.Rect(0, 0, sb.Dx(), stepY))
+	for y := 0; y < h; y++ {
+		y0 := blockStart(sb.Min.Y+y*stepY, stepY, sb.Min.Y, sb.Max.Y)
+		y1 := imin(y0+stepY, sb.Max.Y)
+		rows := band.SubImage(image.Rect(0, 0, sb.Dx(), y1-y0)).(*image.RGBA)
+		draw.Draw(rows, rows.Bounds(), src, image.Pt(sb.Min.X, y0), draw.Src)
+		halveRGBA(dst.SubImage(image.Rect(0, y, w, y+1)).(*image.RGBA), rows, stepX, stepY)
+	}
+	return dst
+}
+
+func halveRGBA(dst, src *image.RGBA, stepX, stepY int) {
+	sb := src.Bounds()
+	b := dst.Bounds()
+	n := uint32(stepX * stepY)
+	for y := 0; y < b.Dy(); y++ {
+		y0 := blockStart(sb.Min.Y+y*stepY, stepY, sb.Min.Y, sb.Max.Y)
+		for x := 0; x < b.Dx(); x++ {
+			x0 := blockStart(sb.Min.X+x*stepX, stepX, sb.Min.X, sb.Max.X)
+			var fr, fg, fb, fa uint32
+			for dy := 0; dy < stepY; dy++ {
+				// A source only one pixel high or wide samples its edge twice.
+				sy := imin(y0+dy, sb.Max.Y-1)
+				for dx := 0; dx < stepX; dx++ {
+					sx := imin(x0+dx, sb.Max.X-1)
+					off := (sy-sb.Min.Y)*src.Stride + (sx-sb.Min.X)*4
+					fr += uint32(src.Pix[off+0])
+					fg += uint32(src.Pix[off+1])
+					fb += uint32(src.Pix[off+2])
+					fa += uint32(src.Pix[off+3])
+				}
+			}
+			off := y*dst.Stride + x*4
+			dst.Pix[off+0] = uint8((fr + n/2) / n)
+			dst.Pix[off+1] = uint8((fg + n/2) / n)
+			dst.Pix[off+2] = uint8((fb + n/2) / n)
+			dst.Pix[off+3] = uint8((fa + n/2) / n)
+		}
+	}
+}
+
+// blockStart returns the first source coordinate of a block of size step
+// starting at v, moved back so that the block ends inside [min, max).
+func blockStart(v, step, min, max int) int {
+	if v+step > max {
+		v = max - step
+	}
+	if v < min {
+		v = min
+	}
+	return v
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
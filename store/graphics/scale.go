@@ -7,13 +7,17 @@ package graphics
 import (
 	"errors"
 	"image"
+	"image/color"
 	"image/draw"
+	"math"
 
 	"github.com/DDHax/sis/store/graphics/interp"
 )
 
-// Scale produces a scaled version of the image using bilinear interpolation.
-func Scale(dst draw.Image, src image.Image) error {
+// Scale produces a scaled version of the image using the interpolator i.
+// A nil i selects bilinear interpolation. Reductions below one half first
+// average the source down a mipmap pyramid so that no pixel is skipped.
+func Scale(dst draw.Image, src image.Image, i interp.Interp) error {
 	if dst == nil {
 		return errors.New("graphics: dst is nil")
 	}
@@ -28,5 +32,74 @@ func Scale(dst draw.Image, src image.Image) error {
 	}
 	sx := float64(b.Dx()) / float64(srcb.Dx())
 	sy := float64(b.Dy()) / float64(srcb.Dy())
-	return I.Scale(sx, sy).Transform(dst, src, interp.Bilinear)
+	if sx < 0.5 || sy < 0.5 {
+		src = reduce(src, sx, sy)
+		srcb = src.Bounds()
+		sx = float64(b.Dx()) / float64(srcb.Dx())
+		sy = float64(b.Dy()) / float64(srcb.Dy())
+	}
+	if i == nil {
+		i = interp.Bilinear
+	}
+	return I.Scale(sx, sy).Transform(dst, src, i)
+}
+
+// ScaleFill produces a scaled version of the image that covers dst while
+// keeping the aspect ratio of src. The overflow is cropped evenly from both
+// sides. A nil i selects bilinear interpolation.
+func ScaleFill(dst draw.Image, src image.Image, i interp.Interp) error {
+	if dst == nil {
+		return errors.New("graphics: dst is nil")
+	}
+	if src == nil {
+		return errors.New("graphics: src is nil")
+	}
+
+	b := dst.Bounds()
+	srcb := src.Bounds()
+	if b.Empty() || srcb.Empty() {
+		return nil
+	}
+	s := math.Max(float64(b.Dx())/float64(srcb.Dx()), float64(b.Dy())/float64(srcb.Dy()))
+	if s < 0.5 {
+		src = reduce(src, s, s)
+		srcb = src.Bounds()
+		s = math.Max(float64(b.Dx())/float64(srcb.Dx()), float64(b.Dy())/float64(srcb.Dy()))
+	}
+	if i == nil {
+		i = interp.Bilinear
+	}
+	return I.Scale(s, s).CenterFit(b, srcb).Transform(dst, src, i)
+}
+
+// ScalePad produces a scaled version of the image that fits inside dst while
+// keeping the aspect ratio of src. The rest of dst is filled with bg.
+// A nil i selects bilinear interpolation.
+func ScalePad(dst draw.Image, src image.Image, bg color.Color, i interp.Interp) error {
+	if dst == nil {
+		return errors.New("graphics: dst is nil")
+	}
+	if src == nil {
+		return errors.New("graphics: src is nil")
+	}
+
+	b := dst.Bounds()
+	srcb := src.Bounds()
+	if b.Empty() {
+		return nil
+	}
+	draw.Draw(dst, b, image.NewUniform(bg), image.Point{}, draw.Src)
+	if srcb.Empty() {
+		return nil
+	}
+	s := math.Min(float64(b.Dx())/float64(srcb.Dx()), float64(b.Dy())/float64(srcb.Dy()))
+	if s < 0.5 {
+		src = reduce(src, s, s)
+		srcb = src.Bounds()
+		s = math.Min(float64(b.Dx())/float64(srcb.Dx()), float64(b.Dy())/float64(srcb.Dy()))
+	}
+	if i == nil {
+		i = interp.Bilinear
+	}
+	return I.Scale(s, s).CenterFit(b, srcb).Transform(dst, src, i)
 }
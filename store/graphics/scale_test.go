@@ -0,0 +1,207 @@
+// Copyright 2011 The Graphics-Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graphics
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+
+	"github.com/DDHax/sis/store/graphics/interp"
+)
+
+var (
+	red   = color.RGBA{0xff, 0, 0, 0xff}
+	blue  = color.RGBA{0, 0, 0xff, 0xff}
+	white = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// newSplit returns a w×h image whose left half is red and right half blue.
+func newSplit(w, h int) *image.RGBA {
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				src.SetRGBA(x, y, red)
+			} else {
+				src.SetRGBA(x, y, blue)
+			}
+		}
+	}
+	return src
+}
+
+func TestScaleFill(t *testing.T) {
+	// Covering a 10×10 box with a 40×20 image crops 10 pixels from
+	// each side, keeping the red/blue split in the middle.
+	src := newSplit(40, 20)
+	dst := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	if err := ScaleFill(dst, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 10; y++ {
+		if c := dst.RGBAAt(1, y); c != red {
+			t.Errorf("(1, %d): got %v want %v", y, c, red)
+		}
+		if c := dst.RGBAAt(8, y); c != blue {
+			t.Errorf("(8, %d): got %v want %v", y, c, blue)
+		}
+	}
+}
+
+func TestScalePad(t *testing.T) {
+	// Fitting a 40×20 image in a 10×10 box leaves 2.5 rows of padding
+	// above and below.
+	src := newSplit(40, 20)
+	dst := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	if err := ScalePad(dst, src, white, nil); err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 10; x++ {
+		for _, y := range []int{0, 1, 8, 9} {
+			if c := dst.RGBAAt(x, y); c != white {
+				t.Errorf("(%d, %d): got %v want background %v", x, y, c, white)
+			}
+		}
+	}
+	if c := dst.RGBAAt(1, 5); c != red {
+		t.Errorf("(1, 5): got %v want %v", c, red)
+	}
+	if c := dst.RGBAAt(8, 5); c != blue {
+		t.Errorf("(8, 5): got %v want %v", c, blue)
+	}
+}
+
+// boxAverage is the reference downscale: every destination pixel is the
+// exact area-weighted average of the source pixels it covers.
+func boxAverage(src *image.Gray, w, h int) *image.Gray {
+	sb := src.Bounds()
+	fx := float64(sb.Dx()) / float64(w)
+	fy := float64(sb.Dy()) / float64(h)
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum, area float64
+			for sy := int(float64(y) * fy); float64(sy) < float64(y+1)*fy; sy++ {
+				oy := math.Min(float64(sy+1), float64(y+1)*fy) - math.Max(float64(sy), float64(y)*fy)
+				for sx := int(float64(x) * fx); float64(sx) < float64(x+1)*fx; sx++ {
+					ox := math.Min(float64(sx+1), float64(x+1)*fx) - math.Max(float64(sx), float64(x)*fx)
+					sum += float64(src.GrayAt(sx, sy).Y) * ox * oy
+					area += ox * oy
+				}
+			}
+			dst.SetGray(x, y, color.Gray{uint8(sum/area + 0.5)})
+		}
+	}
+	return dst
+}
+
+// meanError returns the mean absolute difference between the red channel
+// of got and the gray reference want.
+func meanError(got *image.RGBA, want *image.Gray) float64 {
+	b := want.Bounds()
+	var sum float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			sum += math.Abs(float64(got.RGBAAt(x, y).R) - float64(want.GrayAt(x, y).Y))
+		}
+	}
+	return sum / float64(b.Dx()*b.Dy())
+}
+
+func toRGBA(src *image.Gray) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	draw.Draw(dst, dst.Bounds(), src, image.Point{}, draw.Src)
+	return dst
+}
+
+var reduceTests = []struct {
+	desc string
+	at   func(x, y int) uint8
+	// smooth patterns are already sampled well without reduction, so
+	// the reduced result only has to stay close to the reference.
+	smooth bool
+}{
+	{"one pixel stripes", func(x, y int) uint8 { return uint8(x%2) * 0xff }, false},
+	{"checkerboard", func(x, y int) uint8 { return uint8((x+y)%2) * 0xff }, false},
+	{"fine rings", func(x, y int) uint8 {
+		d := math.Hypot(float64(x-250), float64(y-250))
+		return uint8(127.5 + 127.5*math.Sin(d*d/40))
+	}, false},
+	{"gradient", func(x, y int) uint8 { return uint8((x + y) / 4) }, true},
+}
+
+func TestScaleReduceQuality(t *testing.T) {
+	const srcW, srcH = 500, 500
+	for _, test := range reduceTests {
+		gray := image.NewGray(image.Rect(0, 0, srcW, srcH))
+		for y := 0; y < srcH; y++ {
+			for x := 0; x < srcW; x++ {
+				gray.SetGray(x, y, color.Gray{test.at(x, y)})
+			}
+		}
+		src := toRGBA(gray)
+
+		for _, size := range [][2]int{{50, 50}, {37, 61}, {120, 16}} {
+			want := boxAverage(gray, size[0], size[1])
+
+			// Reduced path.
+			dst := image.NewRGBA(image.Rect(0, 0, size[0], size[1]))
+			if err := Scale(dst, src, nil); err != nil {
+				t.Fatal(err)
+			}
+			got := meanError(dst, want)
+
+			// Direct bilinear sampling, as Scale did before reduction.
+			direct := image.NewRGBA(dst.Bounds())
+			sx := float64(size[0]) / srcW
+			sy := float64(size[1]) / srcH
+			if err := I.Scale(sx, sy).Transform(direct, src, interp.Bilinear); err != nil {
+				t.Fatal(err)
+			}
+			old := meanError(direct, want)
+
+			t.Logf("%s %v: mean error %.2f reduced, %.2f direct", test.desc, size, got, old)
+			if got > 8 {
+				t.Errorf("%s %v: mean error %.2f against the box average, want <= 8", test.desc, size, got)
+			}
+			if !test.smooth && got > old/4 {
+				t.Errorf("%s %v: reduced error %.2f is not well below direct sampling %.2f", test.desc, size, got, old)
+			}
+		}
+	}
+}
+
+func TestHalve(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	copy(src.Pix, []uint8{
+		10, 0, 0, 255, 30, 0, 0, 255, 50, 0, 0, 255,
+		20, 0, 0, 255, 40, 0, 0, 255, 70, 0, 0, 255,
+	})
+	for _, s := range []image.Image{src, struct{ image.Image }{src}} {
+		dst := halve(s, true, true)
+		if b := dst.Bounds(); b.Dx() != 2 || b.Dy() != 1 {
+			t.Fatalf("got bounds %v want 2x1", b)
+		}
+		// (10+30+20+40)/4, and the odd last column blends with its
+		// neighbour: (30+50+40+70)/4 rounded.
+		if r0, r1 := dst.Pix[0], dst.Pix[4]; r0 != 25 || r1 != 48 {
+			t.Errorf("%T: got %d, %d want 25, 48", s, r0, r1)
+		}
+	}
+
+	// A single column is averaged with itself.
+	col := image.NewRGBA(image.Rect(0, 0, 1, 3))
+	copy(col.Pix, []uint8{10, 0, 0, 255, 20, 0, 0, 255, 60, 0, 0, 255})
+	dst := halve(col, true, true)
+	if b := dst.Bounds(); b.Dx() != 1 || b.Dy() != 2 {
+		t.Fatalf("got bounds %v want 1x2", b)
+	}
+	if r0, r1 := dst.Pix[0], dst.Pix[4]; r0 != 15 || r1 != 40 {
+		t.Errorf("single column: got %d, %d want 15, 40", r0, r1)
+	}
+}
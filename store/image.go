@@ -0,0 +1,124 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+
+	"github.com/DDHax/sis/store/graphics"
+	"github.com/DDHax/sis/store/graphics/interp"
+)
+
+//按参数缩放单帧图像，i为nil时使用默认插值算法
+func resize(img image.Image, opt Option, i interp.Interp) (*image.RGBA, error) {
+	//建立目标图形
+	b := img.Bounds()
+	w, h := opt.size(b.Dx(), b.Dy())
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	//执行缩放
+	var err error
+	switch opt.mode() {
+	case ModeFill:
+		err = graphics.ScaleFill(dst, img, i)
+	case ModePad:
+		err = graphics.ScalePad(dst, img, opt.Background, i)
+	default:
+		err = graphics.Scale(dst, img, i)
+	}
+	return dst, err
+}
+
+//依次执行变换，再按参数缩放
+func transform(img image.Image, opt Option) (image.Image, error) {
+	for _, op := range opt.Ops {
+		var err error
+		img, err = op.apply(img, opt.interp())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !opt.scaled() {
+		return img, nil
+	}
+	return resize(img, opt, opt.interp())
+}
+
+//按参数处理图像并转换格式
+func processImage(data []byte, opt Option) ([]byte, error) {
+	config, srcType, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	dstType := opt.Format
+	if dstType == "" {
+		dstType = srcType
+	}
+
+	orientation := opt.orientation(data)
+
+	//不变换、不旋转且格式不变时无需重新编码
+	if !opt.transformed() && orientation == 1 && dstType == srcType && (dstType != "jpeg" || opt.Quality == 0) {
+		return data, nil
+	}
+
+	//解码前检查尺寸，避免解码或处理超大图像耗尽内存
+	if err := checkProcess(config, opt, orientation); err != nil {
+		return nil, err
+	}
+
+	//gif需逐帧缩放，否则动图只剩第一帧
+	if srcType == "gif" && dstType == "gif" {
+		return scaleGIF(data, opt)
+	}
+
+	//解码原始图像
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	//按exif方向摆正后再缩放，目标尺寸以摆正后的宽高为准
+	img, err = orient(img, orientation)
+	if err != nil {
+		return nil, err
+	}
+
+	//执行变换及缩放
+	img, err = transform(img, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	//编码处理后图像
+	var buf bytes.Buffer
+	switch dstType {
+	case "jpeg":
+		err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: opt.quality()})
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		log.Print(dstType)
+		err = errors.New("找不到编码器")
+	}
+	return buf.Bytes(), err
+}
+
+//jpeg不支持透明，将透明图像合成到白色背景上
+func flatten(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func newTestPNG(t *testing.T) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 20; y++ {
+			img.SetNRGBA(x, y, color.NRGBA{0, 0, 0xff, 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessImageFormat(t *testing.T) {
+	src := newTestPNG(t)
+	tests := []struct {
+		opt    Option
+		format string
+		w, h   int
+	}{
+		{Option{Format: "jpeg", Quality: 50}, "jpeg", 40, 20},
+		{Option{Format: "gif"}, "gif", 40, 20},
+		{Option{Width: 10}, "png", 10, 5},
+		{Option{Width: 10, Format: "jpeg"}, "jpeg", 10, 5},
+	}
+	for _, test := range tests {
+		data, err := processImage(src, test.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, format, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); format != test.format || b.Dx() != test.w || b.Dy() != test.h {
+			t.Errorf("%+v: 预期[%s %dx%d], 实际[%s %dx%d]", test.opt, test.format, test.w, test.h, format, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestProcessImageFlatten(t *testing.T) {
+	data, err := processImage(newTestPNG(t), Option{Format: "jpeg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//透明区域转为jpeg后应为白色
+	r, g, b, _ := img.At(30, 10).RGBA()
+	if r>>8 < 0xf0 || g>>8 < 0xf0 || b>>8 < 0xf0 {
+		t.Errorf("透明区域未合成到白色背景 %v", img.At(30, 10))
+	}
+}
+
+func TestProcessImagePassThrough(t *testing.T) {
+	src := newTestPNG(t)
+	data, err := processImage(src, Option{Format: "png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, src) {
+		t.Error("格式不变时不应重新编码")
+	}
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+)
+
+//ErrTooManyPixels 原图像素数超出上限
+var ErrTooManyPixels = errors.New("原图像素数超出上限")
+
+//ErrOutputTooLarge 处理过程中的图像像素数超出上限
+var ErrOutputTooLarge = errors.New("处理结果像素数超出上限")
+
+//像素数上限，0表示不限制
+var (
+	maxSrcPixels int64
+	maxDstPixels int64
+)
+
+//SetLimits 设置原图及处理结果的像素数上限，单位为百万像素，0表示不限制
+func SetLimits(srcMP, dstMP float64) {
+	maxSrcPixels = int64(srcMP * 1e6)
+	maxDstPixels = int64(dstMP * 1e6)
+}
+
+//是否超出像素数上限，以除法比较，宽高很大时乘积不会溢出
+func overLimit(w, h int, limit int64) bool {
+	return limit > 0 && w > 0 && int64(h) > limit/int64(w)
+}
+
+//解码前按原图尺寸推算处理的每一步，任何一步超出上限都不予处理
+func checkProcess(config image.Config, opt Option, orientation int) error {
+	w, h := config.Width, config.Height
+	if overLimit(w, h, maxSrcPixels) {
+		return ErrTooManyPixels
+	}
+	if orientation >= 5 {
+		w, h = h, w
+	}
+	for _, op := range opt.Ops {
+		w, h = op.size(w, h)
+		if overLimit(w, h, maxDstPixels) {
+			return ErrOutputTooLarge
+		}
+	}
+	if opt.scaled() {
+		w, h = opt.size(w, h)
+	}
+	if overLimit(w, h, maxDstPixels) {
+		return ErrOutputTooLarge
+	}
+	return nil
+}
+
+//gif各帧解码后均按逻辑屏幕大小分配，DecodeConfig只报告逻辑屏幕，
+//解码前须逐个读取图像描述符，帧数乘以屏幕像素数超出原图上限时返回ErrTooManyPixels。
+//结构残缺时不报错，交给解码器处理
+func checkGIF(r io.Reader) error {
+	if maxSrcPixels <= 0 {
+		return nil
+	}
+	br := bufio.NewReader(r)
+
+	//文件头及逻辑屏幕描述符
+	var header [13]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil
+	}
+	screen := int64(binary.LittleEndian.Uint16(header[6:])) * int64(binary.LittleEndian.Uint16(header[8:]))
+	if header[10]&0x80 != 0 {
+		br.Discard(3 << (header[10]&7 + 1))
+	}
+
+	var total int64
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil
+		}
+		switch b {
+		case 0x21:
+			//扩展块：标签后接数据子块
+			if _, err := br.ReadByte(); err != nil {
+				return nil
+			}
+		case 0x2c:
+			//图像描述符，逻辑屏幕为空时以帧的尺寸计
+			var desc [9]byte
+			if _, err := io.ReadFull(br, desc[:]); err != nil {
+				return nil
+			}
+			pixels := screen
+			if pixels == 0 {
+				pixels = int64(binary.LittleEndian.Uint16(desc[4:])) * int64(binary.LittleEndian.Uint16(desc[6:]))
+			}
+			total += pixels
+			if total > maxSrcPixels {
+				return ErrTooManyPixels
+			}
+			if desc[8]&0x80 != 0 {
+				br.Discard(3 << (desc[8]&7 + 1))
+			}
+			//LZW最小码长
+			if _, err := br.ReadByte(); err != nil {
+				return nil
+			}
+		default:
+			//结束标记或无法识别的块
+			return nil
+		}
+
+		//跳过数据子块，以长度为0的子块结束
+		for {
+			n, err := br.ReadByte()
+			if err != nil || n == 0 {
+				break
+			}
+			if _, err := br.Discard(int(n)); err != nil {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"image"
+	"os"
+	"testing"
+)
+
+func TestCheckProcess(t *testing.T) {
+	SetLimits(0.002, 0.001)
+	defer SetLimits(0, 0)
+
+	src := image.Config{Width: 40, Height: 40}
+	tests := []struct {
+		config      image.Config
+		opt         Option
+		orientation int
+		err         error
+	}{
+		{src, Option{Width: 20}, 1, nil},
+		{image.Config{Width: 50, Height: 50}, Option{Width: 20}, 1, ErrTooManyPixels},
+		{src, Option{Width: 40}, 1, ErrOutputTooLarge},
+		{src, Option{Width: 100, Height: 10}, 6, nil},
+		//中间步骤超限，即使最终结果很小
+		{src, Option{Width: 10, Ops: []Op{Resize{Width: 400}}}, 1, ErrOutputTooLarge},
+		{src, Option{Ops: []Op{Crop{0, 0, 20, 20}, Rotate{Degrees: 45}}}, 1, nil},
+		{src, Option{Ops: []Op{Crop{0, 0, 30, 30}, Rotate{Degrees: 45}}}, 1, ErrOutputTooLarge},
+	}
+	for i, test := range tests {
+		if err := checkProcess(test.config, test.opt, test.orientation); err != test.err {
+			t.Errorf("%d: 预期[%v], 实际[%v]", i, test.err, err)
+		}
+	}
+}
+
+func TestOverLimit(t *testing.T) {
+	const limit = 50e6
+	tests := []struct {
+		w, h int
+		want bool
+	}{
+		{5000, 10000, false},
+		{5000, 10001, true},
+		{0, 100, false},
+		//乘积溢出int64时仍须判为超限
+		{3037000500, 3037000500, true},
+		{1 << 62, 4, true},
+	}
+	for _, test := range tests {
+		if got := overLimit(test.w, test.h, limit); got != test.want {
+			t.Errorf("%dx%d: 预期[%v], 实际[%v]", test.w, test.h, test.want, got)
+		}
+	}
+
+	//缩放到极大尺寸不能绕过输出上限
+	SetLimits(100, 50)
+	defer SetLimits(0, 0)
+	for _, mode := range []string{ModeExact, ModeFit} {
+		opt := Option{Width: 3037000500, Height: 3037000500, Mode: mode}
+		if err := checkProcess(image.Config{Width: 100, Height: 100}, opt, 1); err != ErrOutputTooLarge {
+			t.Errorf("%s: 预期[%v], 实际[%v]", mode, ErrOutputTooLarge, err)
+		}
+	}
+}
+
+func TestCheckUpload(t *testing.T) {
+	f, err := os.Open("testdata/orientation_1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	//48x32的图像
+	SetLimits(0.001, 0)
+	defer SetLimits(0, 0)
+	if err := checkUpload(f); err != ErrTooManyPixels {
+		t.Errorf("预期[%v], 实际[%v]", ErrTooManyPixels, err)
+	}
+	if _, err := processImage(readOrientation(t, 1), Option{Width: 10}); err != ErrTooManyPixels {
+		t.Errorf("预期[%v], 实际[%v]", ErrTooManyPixels, err)
+	}
+
+	SetLimits(0.002, 0)
+	if err := checkUpload(f); err != nil {
+		t.Error(err)
+	}
+	if off, _ := f.Seek(0, 1); off != 0 {
+		t.Errorf("检查后应回到文件起点, 实际[%d]", off)
+	}
+}
+
+func TestCheckGIF(t *testing.T) {
+	//40x20的逻辑屏幕，3帧
+	data := newTestGIF(t)
+	defer SetLimits(0, 0)
+
+	SetLimits(0.001, 0)
+	if err := checkUpload(openTemp(t, data)); err != ErrTooManyPixels {
+		t.Errorf("预期[%v], 实际[%v]", ErrTooManyPixels, err)
+	}
+	if _, err := processImage(data, Option{Width: 20}); err != ErrTooManyPixels {
+		t.Errorf("预期[%v], 实际[%v]", ErrTooManyPixels, err)
+	}
+
+	SetLimits(0.0024, 0)
+	f := openTemp(t, data)
+	if err := checkUpload(f); err != nil {
+		t.Error(err)
+	}
+	if off, _ := f.Seek(0, 1); off != 0 {
+		t.Errorf("检查后应回到文件起点, 实际[%d]", off)
+	}
+	if _, err := processImage(data, Option{Width: 20}); err != nil {
+		t.Error(err)
+	}
+}
@@ -5,14 +5,17 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
-	"mime/multipart"
 	"os"
 	"path"
+	"strings"
 )
 
 //原始文件保存目录名
 const sourceDirName = "src"
 
+//上传临时文件目录名，以.开头，遍历时跳过
+const tempDirName = ".tmp"
+
 type localStore struct {
 }
 
@@ -35,7 +38,7 @@ func (s localStore) getSrcPath(md5 string) string {
 	return buf.String()
 }
 
-func (s localStore) write(f multipart.File, md5 string, name string) error {
+func (s localStore) write(f *os.File, md5 string, name string) error {
 	//创建目录
 	srcPath := s.getSrcPath(md5)
 	err := os.MkdirAll(srcPath, os.ModePerm)
@@ -43,7 +46,15 @@ func (s localStore) write(f multipart.File, md5 string, name string) error {
 		return err
 	}
 
-	//创建文件
+	//临时文件与存储目录在同一文件系统，直接移动
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), srcPath+name); err == nil {
+		return nil
+	}
+
+	//移动失败时复制
 	destFile, err := os.Create(srcPath + name)
 	if err != nil {
 		return err
@@ -58,8 +69,13 @@ func (s localStore) write(f multipart.File, md5 string, name string) error {
 
 	//文件落地
 	_, err = io.Copy(destFile, f)
+	return err
+}
 
-	return nil
+//临时文件放在存储目录下
+func (s localStore) tempDir() (string, error) {
+	dir := path.Join(imagePath, tempDirName)
+	return dir, os.MkdirAll(dir, os.ModePerm)
 }
 
 func (s localStore) getDirFirstFile(dir string) (string, error) {
@@ -76,7 +92,7 @@ func (s localStore) getDirFirstFile(dir string) (string, error) {
 	return "", errors.New("目录中没有文件")
 }
 
-func (s localStore) read(md5Code string, fileName *string) ([]byte, error) {
+func (s localStore) read(md5Code string, fileName *string) (*File, error) {
 
 	//获取文件路径
 	filePath := s.getSrcPath(md5Code)
@@ -91,6 +107,143 @@ func (s localStore) read(md5Code string, fileName *string) ([]byte, error) {
 		filePath = filePath + *fileName
 	}
 
-	//读取文件
-	return ioutil.ReadFile(filePath)
+	//打开文件，由调用方关闭
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &File{ReadSeeker: f, Size: info.Size(), ModTime: info.ModTime(), closer: f}, nil
+}
+
+func (s localStore) remove(md5Code string, fileName string) error {
+	if !validMD5(md5Code) {
+		return errors.New("md5格式错误")
+	}
+	dir := s.md5ToPath(md5Code)
+
+	if fileName == "" {
+		//未指定文件名，删除该md5下全部内容
+		if _, err := os.Stat(dir); err != nil {
+			return err
+		}
+	} else {
+		//删除原始文件
+		srcPath := s.getSrcPath(md5Code)
+		err := os.Remove(srcPath + fileName)
+		if err != nil {
+			return err
+		}
+
+		//还有其他原始文件时保留目录
+		files, err := ioutil.ReadDir(srcPath)
+		if err != nil || len(files) > 0 {
+			return err
+		}
+	}
+
+	//删除原始文件及缩放文件所在目录
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+	s.pruneDir(path.Dir(path.Clean(dir)))
+	return nil
+}
+
+//自下而上清理md5拆解出的空目录，遇到非空目录即停止
+func (s localStore) pruneDir(dir string) {
+	root := path.Clean(imagePath)
+	for dir != root && len(dir) > len(root) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+func (s localStore) list(prefix, cursor string, limit int) (Page, error) {
+	var page Page
+	_, err := s.walk(path.Clean(imagePath), "", prefix, cursor, limit, &page)
+	if os.IsNotExist(err) {
+		//尚未存储任何文件
+		err = nil
+	}
+	return page, err
+}
+
+//按字典序深度遍历md5目录树，收集大于cursor且以prefix开头的md5，凑满一页后返回true
+func (s localStore) walk(dir, md5Code, prefix, cursor string, limit int, page *Page) (bool, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	//先处理当前目录自身，其md5小于所有子目录
+	for _, file := range files {
+		if file.Name() != sourceDirName || !file.IsDir() {
+			continue
+		}
+		if md5Code > cursor && strings.HasPrefix(md5Code, prefix) {
+			if len(page.Items) == limit {
+				page.Next = page.Items[limit-1]
+				return true, nil
+			}
+			page.Items = append(page.Items, md5Code)
+		}
+	}
+
+	for _, file := range files {
+		if file.Name() == sourceDirName || !file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		code := md5Code + file.Name()
+
+		//剪枝：与prefix不匹配的子树
+		n := len(code)
+		if n > len(prefix) {
+			n = len(prefix)
+		}
+		if code[:n] != prefix[:n] {
+			continue
+		}
+
+		//剪枝：整棵子树都不大于cursor
+		if code < cursor && !strings.HasPrefix(cursor, code) {
+			continue
+		}
+
+		full, err := s.walk(dir+string(os.PathSeparator)+file.Name(), code, prefix, cursor, limit, page)
+		if full || err != nil {
+			return full, err
+		}
+	}
+	return false, nil
+}
+
+func (s localStore) stat(md5Code string) (Meta, error) {
+	meta := Meta{MD5: md5Code}
+	srcPath := s.getSrcPath(md5Code)
+	files, err := ioutil.ReadDir(srcPath)
+	if err != nil {
+		return meta, err
+	}
+
+	for _, file := range files {
+		info := FileInfo{
+			Name:       file.Name(),
+			Size:       file.Size(),
+			UploadTime: file.ModTime(),
+		}
+		if f, err := os.Open(srcPath + file.Name()); err == nil {
+			info.Format, info.Width, info.Height = decodeConfig(f)
+			f.Close()
+		}
+		meta.Files = append(meta.Files, info)
+	}
+	return meta, nil
 }
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestLocalWriteMovesTempFile(t *testing.T) {
+	Init(t.TempDir(), true, 0)
+	data := readOrientation(t, 1)
+	const md5Code = "0123456789abcdef0123456789abcdef"
+
+	f, err := TempFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	info, err := Write(f, md5Code, "a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != "jpeg" || info.Width != 48 || info.Height != 32 || info.Size != int64(len(data)) {
+		t.Errorf("非预期文件信息 %+v", info)
+	}
+
+	//临时文件已被移动
+	if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Errorf("临时文件仍存在 %v", err)
+	}
+	name := "a.jpg"
+	got, err := Read(md5Code, &name, Option{NoRotate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := got.bytes()
+	got.Close()
+	if err != nil || !bytes.Equal(b, data) || got.Size != int64(len(data)) {
+		t.Errorf("读取内容不一致 %v", err)
+	}
+
+	//遍历时跳过临时目录
+	page, err := List("", "", 10)
+	if err != nil || len(page.Items) != 1 || page.Items[0] != md5Code {
+		t.Errorf("非预期列表 %+v %v", page, err)
+	}
+}
+
+//缓存命中时仍带原图修改时间，以便回复Last-Modified
+func TestCachedReadModTime(t *testing.T) {
+	Init(t.TempDir(), true, 1)
+	defer Init("", true, 0)
+	data := readOrientation(t, 1)
+	const md5Code = "0123456789abcdef0123456789abcdef"
+
+	f, err := TempFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Write(f, md5Code, "a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(localStore{}.getSrcPath(md5Code) + "a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt := Option{Width: 24, NoRotate: true}
+	for i, o := range []Option{{NoRotate: true}, opt, opt} {
+		name := "a.jpg"
+		got, err := Read(md5Code, &name, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.Close()
+		if !got.ModTime.Equal(stat.ModTime()) {
+			t.Errorf("第%d次读取, 预期修改时间[%v], 实际[%v]", i+1, stat.ModTime(), got.ModTime)
+		}
+	}
+}
+
+//md5不完整时不能删除，否则会删掉同前缀的全部文件
+func TestDeleteBadMD5(t *testing.T) {
+	Init(t.TempDir(), true, 0)
+	const md5Code = "a123456789abcdef0123456789abcdef"
+
+	f, err := TempFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(readOrientation(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Write(f, md5Code, "a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{"", "a", "a1", md5Code[:31], md5Code[:31] + "g", md5Code + "0"} {
+		if err := Delete(s, ""); err == nil {
+			t.Errorf("%q: 应返回错误", s)
+		}
+		if err := (localStore{}).remove(s, ""); err == nil {
+			t.Errorf("%q: 应返回错误", s)
+		}
+	}
+	if _, err := os.Stat(localStore{}.getSrcPath(md5Code) + "a.jpg"); err != nil {
+		t.Fatalf("文件被误删 %v", err)
+	}
+	if err := Delete(md5Code, ""); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"encoding/hex"
+	"errors"
+	"image/color"
+	"math"
+	"strconv"
+
+	"github.com/DDHax/sis/store/graphics/interp"
+)
+
+//缩放模式
+const (
+	ModeExact = "exact" //拉伸到指定尺寸，默认模式
+	ModeFit   = "fit"   //保持比例缩放到指定尺寸以内
+	ModeFill  = "fill"  //保持比例缩放到覆盖指定尺寸，居中裁掉多余部分
+	ModePad   = "pad"   //保持比例缩放到指定尺寸以内，空白处以背景色填充
+)
+
+//Option 图像处理参数，零值表示读取原始文件
+type Option struct {
+	Width      int        //目标宽度，为0时按原图比例由高度推算
+	Height     int        //目标高度，为0时按原图比例由宽度推算
+	Mode       string     //缩放模式，为空时使用ModeExact
+	Background color.RGBA //ModePad的背景色
+	Filter     string     //插值算法，为空时使用bilinear
+	Format     string     //输出格式，jpeg、png或gif，为空时与原图一致
+	Quality    int        //jpeg输出质量，1到100，为0时使用defaultQuality
+	NoRotate   bool       //不按exif方向自动摆正jpeg
+	Ops        []Op       //缩放前按顺序执行的变换
+}
+
+//MaxSize 缩放及裁剪的宽高上限
+const MaxSize = 1024 * 1024
+
+//jpeg默认输出质量
+const defaultQuality = 100
+
+//可选的插值算法
+var filters = map[string]interp.Interp{
+	"nearest":    interp.NearestNeighbor,
+	"bilinear":   interp.Bilinear,
+	"bicubic":    interp.Bicubic,
+	"catmullrom": interp.CatmullRom,
+	"mitchell":   interp.MitchellNetravali,
+	"lanczos3":   interp.Lanczos3,
+}
+
+//Valid 检测参数是否合法
+func (o Option) Valid() bool {
+	if o.Width < 0 || o.Height < 0 || o.Width > MaxSize || o.Height > MaxSize {
+		return false
+	}
+	if _, ok := filters[o.Filter]; !ok && o.Filter != "" {
+		return false
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return false
+	}
+	for _, op := range o.Ops {
+		if op == nil || !op.valid() {
+			return false
+		}
+	}
+	switch o.Format {
+	case "", "jpeg", "png", "gif":
+	default:
+		return false
+	}
+	switch o.Mode {
+	case "", ModeExact, ModeFit, ModeFill, ModePad:
+		return true
+	}
+	return false
+}
+
+//ParseColor 解析RRGGBB或RRGGBBAA格式的16进制颜色，空字符串表示白色
+func ParseColor(s string) (color.RGBA, error) {
+	if s == "" {
+		return color.RGBA{0xff, 0xff, 0xff, 0xff}, nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || (len(b) != 3 && len(b) != 4) {
+		return color.RGBA{}, errors.New("颜色格式错误")
+	}
+	if len(b) == 3 {
+		b = append(b, 0xff)
+	}
+
+	//转换为预乘alpha格式
+	a := uint32(b[3])
+	return color.RGBA{
+		R: uint8(uint32(b[0]) * a / 0xff),
+		G: uint8(uint32(b[1]) * a / 0xff),
+		B: uint8(uint32(b[2]) * a / 0xff),
+		A: b[3],
+	}, nil
+}
+
+//是否需要缩放
+func (o Option) scaled() bool {
+	return o.Width > 0 || o.Height > 0
+}
+
+//是否用到插值算法，缩放及非直角旋转的结果随插值算法不同
+func (o Option) interpolated() bool {
+	if o.scaled() {
+		return true
+	}
+	for _, op := range o.Ops {
+		switch op := op.(type) {
+		case Resize:
+			return true
+		case Rotate:
+			if math.Mod(op.degrees(), 90) != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//是否需要变换或缩放
+func (o Option) transformed() bool {
+	return o.scaled() || len(o.Ops) > 0
+}
+
+//Processed 是否需要处理原图，包括变换、缩放和格式转换
+func (o Option) Processed() bool {
+	return o.transformed() || o.Format != "" || o.Quality != 0
+}
+
+//jpeg输出质量
+func (o Option) quality() int {
+	if o.Quality == 0 {
+		return defaultQuality
+	}
+	return o.Quality
+}
+
+//实际生效的缩放模式，只指定一边时各模式效果相同
+func (o Option) mode() string {
+	if o.Width == 0 || o.Height == 0 || o.Mode == "" {
+		return ModeExact
+	}
+	return o.Mode
+}
+
+//根据原图尺寸计算目标尺寸
+func (o Option) size(srcW, srcH int) (w, h int) {
+	w, h = o.Width, o.Height
+	switch {
+	case w == 0:
+		w = scaleLength(srcW, float64(h)/float64(srcH))
+	case h == 0:
+		h = scaleLength(srcH, float64(w)/float64(srcW))
+	case o.mode() == ModeFit:
+		s := math.Min(float64(w)/float64(srcW), float64(h)/float64(srcH))
+		w, h = scaleLength(srcW, s), scaleLength(srcH, s)
+	}
+	return w, h
+}
+
+//按比例缩放长度，结果至少为1
+func scaleLength(n int, s float64) int {
+	l := int(float64(n)*s + 0.5)
+	if l < 1 {
+		return 1
+	}
+	return l
+}
+
+//需要应用的exif方向，关闭自动旋转时返回1
+func (o Option) orientation(data []byte) int {
+	if o.NoRotate {
+		return 1
+	}
+	return jpegOrientation(data)
+}
+
+//插值算法，未指定时返回nil由graphics选择默认算法
+func (o Option) interp() interp.Interp {
+	return filters[o.Filter]
+}
+
+//缓存key后缀，不同处理参数的结果分别缓存
+func (o Option) key() string {
+	//不处理时，摆正后的原图与原始文件分别缓存
+	if !o.Processed() {
+		if o.NoRotate {
+			return ""
+		}
+		return "_o"
+	}
+
+	var key string
+	if o.scaled() {
+		key = strconv.Itoa(o.Width) + "_" + strconv.Itoa(o.Height)
+		if mode := o.mode(); mode != ModeExact {
+			key = key + "_" + mode
+			if mode == ModePad {
+				c := o.Background
+				key = key + "_" + hex.EncodeToString([]byte{c.R, c.G, c.B, c.A})
+			}
+		}
+	}
+	for _, op := range o.Ops {
+		key = key + op.key()
+	}
+	if o.interpolated() && o.Filter != "" && o.Filter != "bilinear" {
+		key = key + "_" + o.Filter
+	}
+	if o.Format != "" {
+		key = key + "_f" + o.Format
+	}
+	if o.Quality != 0 {
+		key = key + "_q" + strconv.Itoa(o.Quality)
+	}
+	if o.NoRotate {
+		key = key + "_norot"
+	}
+	return key
+}
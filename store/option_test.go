@@ -0,0 +1,78 @@
+package store
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestOptionSize(t *testing.T) {
+	tests := []struct {
+		opt        Option
+		srcW, srcH int
+		w, h       int
+	}{
+		{Option{Width: 200, Height: 100}, 400, 400, 200, 100},
+		{Option{Width: 200, Height: 100, Mode: ModeFit}, 400, 400, 100, 100},
+		{Option{Width: 200, Height: 100, Mode: ModeFit}, 800, 200, 200, 50},
+		{Option{Width: 200, Height: 100, Mode: ModeFill}, 400, 400, 200, 100},
+		{Option{Width: 200, Height: 100, Mode: ModePad}, 400, 400, 200, 100},
+		{Option{Width: 200}, 400, 300, 200, 150},
+		{Option{Height: 100, Mode: ModeFill}, 400, 300, 133, 100},
+		{Option{Width: 5}, 4000, 10, 5, 1},
+	}
+	for _, test := range tests {
+		w, h := test.opt.size(test.srcW, test.srcH)
+		if w != test.w || h != test.h {
+			t.Errorf("%+v %dx%d: 预期[%dx%d], 实际[%dx%d]", test.opt, test.srcW, test.srcH, test.w, test.h, w, h)
+		}
+	}
+}
+
+func TestOptionKey(t *testing.T) {
+	white, _ := ParseColor("")
+	tests := []struct {
+		opt Option
+		key string
+	}{
+		{Option{}, "_o"},
+		{Option{NoRotate: true}, ""},
+		{Option{Width: 200, Height: 100}, "200_100"},
+		{Option{Width: 200, Height: 100, Mode: ModeExact, Filter: "bilinear"}, "200_100"},
+		{Option{Width: 200, Mode: ModeFill}, "200_0"},
+		{Option{Width: 200, Height: 100, Mode: ModeFill, Filter: "lanczos3"}, "200_100_fill_lanczos3"},
+		{Option{Width: 200, Height: 100, Mode: ModePad, Background: white}, "200_100_pad_ffffffff"},
+		{Option{Format: "png"}, "_fpng"},
+		{Option{Width: 200, Height: 100, Format: "jpeg", Quality: 80}, "200_100_fjpeg_q80"},
+		{Option{Width: 200, NoRotate: true}, "200_0_norot"},
+		//管道中的缩放及非直角旋转同样区分插值算法
+		{Option{Ops: []Op{Resize{Width: 300}}}, "_rs300_0"},
+		{Option{Ops: []Op{Resize{Width: 300}}, Filter: "nearest"}, "_rs300_0_nearest"},
+		{Option{Ops: []Op{Rotate{Degrees: 45, Background: white}}, Filter: "lanczos3"}, "_r45_ffffffff_lanczos3"},
+		{Option{Ops: []Op{Rotate{Degrees: 90}, Flip{}}, Filter: "nearest"}, "_r90_fliph"},
+	}
+	for _, test := range tests {
+		if key := test.opt.key(); key != test.key {
+			t.Errorf("%+v: 预期[%s], 实际[%s]", test.opt, test.key, key)
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		s   string
+		c   color.RGBA
+		err bool
+	}{
+		{"", color.RGBA{0xff, 0xff, 0xff, 0xff}, false},
+		{"102030", color.RGBA{0x10, 0x20, 0x30, 0xff}, false},
+		{"ff000080", color.RGBA{0x80, 0, 0, 0x80}, false},
+		{"fff", color.RGBA{}, true},
+		{"gggggg", color.RGBA{}, true},
+	}
+	for _, test := range tests {
+		c, err := ParseColor(test.s)
+		if (err != nil) != test.err || c != test.c {
+			t.Errorf("%q: 预期[%v %v], 实际[%v %v]", test.s, test.c, test.err, c, err)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//StepError 处理管道中某一步的错误
+type StepError struct {
+	Step int    //步骤序号，从1开始
+	Text string //步骤原文
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("第%d步[%s]：%v", e.Step, e.Text, e.Err)
+}
+
+//PipelineError 处理管道的全部错误，每个出错的步骤一条
+type PipelineError []*StepError
+
+func (e PipelineError) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "；")
+}
+
+//单个步骤的解析函数，args为步骤名之后以:分隔的参数
+type stepFunc func(opt *Option, args []string) error
+
+//步骤名及其缩写
+var steps = map[string]stepFunc{
+	"rs":         stepResize,
+	"resize":     stepResize,
+	"c":          stepOp("crop"),
+	"crop":       stepOp("crop"),
+	"rot":        stepOp("rotate"),
+	"rotate":     stepOp("rotate"),
+	"fl":         stepOp("flip"),
+	"flip":       stepOp("flip"),
+	"q":          stepQuality,
+	"quality":    stepQuality,
+	"f":          stepFormat,
+	"format":     stepFormat,
+	"fi":         stepFilter,
+	"filter":     stepFilter,
+	"bg":         stepBackground,
+	"background": stepBackground,
+	"ar":         stepAutoRotate,
+	"autorotate": stepAutoRotate,
+	"pr":         stepPreset,
+	"preset":     stepPreset,
+}
+
+//ParsePipeline 解析处理管道，每一步形如name:arg1:arg2，例如
+//rs:fill:300:200、q:80、f:png、rot:90。变换类步骤按出现顺序执行，
+//格式、质量等输出参数出现在任意位置均对整条管道生效
+func ParsePipeline(pipeline []string) (Option, error) {
+	//背景色默认为白色，与缩放接口一致
+	var opt Option
+	opt.Background, _ = ParseColor("")
+	var errs PipelineError
+	for i, text := range pipeline {
+		args := strings.Split(text, ":")
+		var err error
+		if f, ok := steps[args[0]]; ok {
+			err = f(&opt, args[1:])
+		} else {
+			err = errors.New("未知的步骤")
+		}
+		if err != nil {
+			errs = append(errs, &StepError{i + 1, text, err})
+		}
+	}
+	if errs != nil {
+		return opt, errs
+	}
+
+	//背景色对管道中的缩放和旋转统一生效
+	for i, op := range opt.Ops {
+		switch op := op.(type) {
+		case Resize:
+			op.Background = opt.Background
+			opt.Ops[i] = op
+		case Rotate:
+			op.Background = opt.Background
+			opt.Ops[i] = op
+		}
+	}
+	if !opt.Valid() {
+		return opt, ErrBadOption
+	}
+	return opt, nil
+}
+
+//rs:mode:w:h，宽高为0或缺省时按原图比例推算
+func stepResize(opt *Option, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New("格式应为rs:mode:w:h")
+	}
+	var size [2]int
+	for i, s := range args[1:] {
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return errors.New("宽高须为非负整数")
+		}
+		if n > MaxSize {
+			return fmt.Errorf("宽高不能超过%d", MaxSize)
+		}
+		size[i] = n
+	}
+
+	r := Resize{Width: size[0], Height: size[1], Mode: args[0]}
+	if !r.option().scaled() {
+		return errors.New("宽高不能同时为0")
+	}
+	if !r.valid() {
+		return errors.New("未知的缩放模式")
+	}
+	opt.Ops = append(opt.Ops, r)
+	return nil
+}
+
+//crop、rotate、flip，参数与/transform接口相同，crop的坐标以:分隔
+func stepOp(name string) stepFunc {
+	return func(opt *Option, args []string) error {
+		if len(args) == 0 {
+			return errors.New("缺少参数")
+		}
+		op, err := ParseOp(name, strings.Join(args, ","), opt.Background)
+		if err != nil {
+			return err
+		}
+		if !op.valid() {
+			return errors.New("参数超出范围")
+		}
+		opt.Ops = append(opt.Ops, op)
+		return nil
+	}
+}
+
+//只接受一个参数的步骤
+func oneArg(args []string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return "", errors.New("需要一个参数")
+	}
+	return args[0], nil
+}
+
+func stepQuality(opt *Option, args []string) error {
+	s, err := oneArg(args)
+	if err != nil {
+		return err
+	}
+	q, err := strconv.Atoi(s)
+	if err != nil || q < 1 || q > 100 {
+		return errors.New("质量须为1到100的整数")
+	}
+	opt.Quality = q
+	return nil
+}
+
+func stepFormat(opt *Option, args []string) error {
+	s, err := oneArg(args)
+	if err != nil {
+		return err
+	}
+	switch s {
+	case "jpg", "jpeg":
+		opt.Format = "jpeg"
+	case "png", "gif":
+		opt.Format = s
+	default:
+		return errors.New("不支持的格式")
+	}
+	return nil
+}
+
+func stepFilter(opt *Option, args []string) error {
+	s, err := oneArg(args)
+	if err != nil {
+		return err
+	}
+	if _, ok := filters[s]; !ok {
+		return errors.New("未知的插值算法")
+	}
+	opt.Filter = s
+	return nil
+}
+
+func stepBackground(opt *Option, args []string) error {
+	s, err := oneArg(args)
+	if err != nil {
+		return err
+	}
+	c, err := ParseColor(s)
+	if err != nil {
+		return err
+	}
+	opt.Background = c
+	return nil
+}
+
+func stepAutoRotate(opt *Option, args []string) error {
+	s, err := oneArg(args)
+	if err != nil {
+		return err
+	}
+	switch s {
+	case "0":
+		opt.NoRotate = true
+	case "1":
+		opt.NoRotate = false
+	default:
+		return errors.New("取值须为0或1")
+	}
+	return nil
+}
+
+//pr:name，展开预设，之后的步骤可继续追加或覆盖
+func stepPreset(opt *Option, args []string) error {
+	s, err := oneArg(args)
+	if err != nil {
+		return err
+	}
+	p, ok := Preset(s)
+	if !ok {
+		return errors.New("未知的预设")
+	}
+	opt.Ops = append(opt.Ops, p.Ops...)
+	opt.Background = p.Background
+	if p.Filter != "" {
+		opt.Filter = p.Filter
+	}
+	if p.Format != "" {
+		opt.Format = p.Format
+	}
+	if p.Quality != 0 {
+		opt.Quality = p.Quality
+	}
+	opt.NoRotate = opt.NoRotate || p.NoRotate
+	return nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestParsePipeline(t *testing.T) {
+	opt, err := ParsePipeline(strings.Split("rs:fill:300:200/q:80/f:png/rot:90", "/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.Quality != 80 || opt.Format != "png" || len(opt.Ops) != 2 {
+		t.Fatalf("解析结果错误 %+v", opt)
+	}
+	if r, ok := opt.Ops[0].(Resize); !ok || r.Width != 300 || r.Height != 200 || r.Mode != ModeFill {
+		t.Errorf("第1步应为缩放 %+v", opt.Ops[0])
+	}
+	if k := opt.key(); k != "_rs300_200_fill_r90_fpng_q80" {
+		t.Errorf("缓存key错误 %s", k)
+	}
+
+	//缩放与旋转的先后决定结果尺寸
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for _, test := range []struct {
+		pipeline string
+		size     image.Point
+	}{
+		{"rs:exact:20:0/rot:90", image.Pt(10, 20)},
+		{"rot:90/rs:exact:20:0", image.Pt(20, 40)},
+		{"c:0:0:10:10/fl:v/rs:pad:30:20/bg:00000000", image.Pt(30, 20)},
+	} {
+		opt, err := ParsePipeline(strings.Split(test.pipeline, "/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := transform(img, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := dst.Bounds().Size(); s != test.size {
+			t.Errorf("%s: 预期[%v], 实际[%v]", test.pipeline, test.size, s)
+		}
+	}
+}
+
+func TestPipelineMaxSize(t *testing.T) {
+	for _, pipeline := range []string{"rs:exact:3037000500:3037000500", "rs:fit:0:1048577", "c:0:0:1048577:10"} {
+		if _, err := ParsePipeline([]string{pipeline}); err == nil {
+			t.Errorf("%s: 超出尺寸上限未报错", pipeline)
+		}
+	}
+	if _, err := ParsePipeline([]string{"rs:fit:1048576:1048576"}); err != nil {
+		t.Error(err)
+	}
+	if (Option{Width: MaxSize + 1}).Valid() {
+		t.Error("Option宽度超出上限仍有效")
+	}
+}
+
+func TestPipelineError(t *testing.T) {
+	_, err := ParsePipeline([]string{"rs:fill:300:200", "q:0", "f:png", "x:1", "rs:pad:0:0"})
+	errs, ok := err.(PipelineError)
+	if !ok {
+		t.Fatalf("预期PipelineError, 实际[%v]", err)
+	}
+
+	want := []int{2, 4, 5}
+	if len(errs) != len(want) {
+		t.Fatalf("错误数量不符 %v", errs)
+	}
+	for i, step := range want {
+		if errs[i].Step != step {
+			t.Errorf("预期第%d步出错, 实际[%v]", step, errs[i])
+		}
+	}
+}
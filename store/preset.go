@@ -0,0 +1,114 @@
+package store
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strings"
+)
+
+//预设名称对应的处理参数
+var presets map[string]Option
+
+//上传后预生成预设的任务队列，为nil时不预生成
+var warmQueue chan warmTask
+
+//预生成队列长度，队列满时丢弃新任务
+const warmQueueSize = 1024
+
+type warmTask struct {
+	md5, name string
+}
+
+//ParsePresets 解析预设配置，各预设以;分隔，每个预设为 名称=处理管道，如
+//avatar=rs:fill:64:64;card=rs:fill:320:240/q:80;hero=rs:fit:1280:0
+func ParsePresets(s string) (map[string]Option, error) {
+	p := make(map[string]Option)
+	for _, item := range strings.Split(s, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, pipeline, ok := strings.Cut(item, "=")
+		if !ok || name == "" || pipeline == "" {
+			return nil, errors.New("预设格式错误：" + item)
+		}
+		if _, ok := p[name]; ok {
+			return nil, errors.New("预设重复：" + name)
+		}
+		opt, err := ParsePipeline(strings.Split(pipeline, "/"))
+		if err != nil {
+			return nil, errors.New("预设" + name + "：" + err.Error())
+		}
+		if !opt.Processed() {
+			return nil, errors.New("预设" + name + "：没有处理参数")
+		}
+		p[name] = opt
+	}
+	return p, nil
+}
+
+//SetPresets 设置预设，warm为true且启用了缓存时，上传成功后在后台预生成各预设
+func SetPresets(p map[string]Option, warm bool) {
+	presets = p
+	if warm && len(p) > 0 && gCache.isEnable() && warmQueue == nil {
+		warmQueue = make(chan warmTask, warmQueueSize)
+		go warmer(warmQueue)
+	}
+}
+
+//Preset 按名称查找预设
+func Preset(name string) (Option, bool) {
+	opt, ok := presets[name]
+	return opt, ok
+}
+
+//IsPreset 处理参数是否与某个预设相同，是否按exif方向摆正不影响判断
+func IsPreset(opt Option) bool {
+	opt.NoRotate = false
+	key := opt.key()
+	for _, p := range presets {
+		if p.key() == key {
+			return true
+		}
+	}
+	return false
+}
+
+//将上传的文件加入预生成队列，不阻塞上传
+func enqueueWarm(md5, name string) {
+	if warmQueue == nil {
+		return
+	}
+	select {
+	case warmQueue <- warmTask{md5, name}:
+	default:
+		log.Printf("预生成队列已满，跳过 %s %s", md5, name)
+	}
+}
+
+//逐个文件生成全部预设，结果由Read写入缓存
+func warmer(queue chan warmTask) {
+	//按名称排序，便于排查日志
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for t := range queue {
+		for _, name := range names {
+			opt := presets[name]
+			name := t.name
+			f, err := Read(t.md5, &name, opt)
+			if err != nil {
+				log.Printf("预生成失败 %s %s: %v", t.md5, t.name, err)
+				break
+			}
+			data, _ := f.bytes()
+			f.Close()
+
+			//不带文件名下载时的缓存key不同，共用同一份数据
+			gCache.memWrite(t.md5+opt.key(), data, f.ModTime)
+		}
+	}
+}
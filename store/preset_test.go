@@ -0,0 +1,64 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePresets(t *testing.T) {
+	p, err := ParsePresets("avatar=rs:fill:64:64; card=rs:fill:320:240/q:80 ;hero=rs:fit:1280:0/f:jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 3 || p["card"].Quality != 80 || p["hero"].Format != "jpeg" {
+		t.Fatalf("解析结果错误 %+v", p)
+	}
+	if p, err := ParsePresets(""); err != nil || len(p) != 0 {
+		t.Errorf("空配置应返回空预设 %v %v", p, err)
+	}
+
+	for _, s := range []string{"card", "card=", "a=rs:fill:1:1;a=q:80", "a=q:0", "a=ar:0"} {
+		if _, err := ParsePresets(s); err == nil {
+			t.Errorf("%s: 应返回错误", s)
+		}
+	}
+}
+
+func TestPresetPipeline(t *testing.T) {
+	p, err := ParsePresets("card=rs:fill:320:240/q:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetPresets(p, false)
+	defer SetPresets(nil, false)
+
+	opt, err := ParsePipeline([]string{"pr:card"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPreset(opt) || opt.key() != p["card"].key() {
+		t.Errorf("展开预设结果错误 %s", opt.key())
+	}
+
+	//预设可关闭自动摆正
+	opt, err = ParsePipeline(strings.Split("pr:card/ar:0", "/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPreset(opt) || !opt.NoRotate {
+		t.Errorf("关闭自动摆正后应仍为预设 %s", opt.key())
+	}
+
+	//追加步骤后不再是预设
+	opt, err = ParsePipeline(strings.Split("pr:card/f:png", "/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsPreset(opt) || opt.Quality != 80 || opt.Format != "png" {
+		t.Errorf("追加步骤结果错误 %+v", opt)
+	}
+
+	if _, err := ParsePipeline([]string{"pr:hero"}); err == nil {
+		t.Error("未知预设应返回错误")
+	}
+}
@@ -2,68 +2,321 @@ package store
 
 import (
 	"bufio"
-	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	urlDerectUp   = "/derect_up"
-	urlSimpleDown = "/simple_down?md5=%s"
-	urlFullDown   = "/full_down?md5=%s&file_name=%s"
+	urlSimpleDown = "/simple_down?md5=%s&autorotate=0"
+	urlFullDown   = "/full_down?md5=%s&file_name=%s&autorotate=0"
+	urlDelete     = "/delete?md5=%s&file_name=%s"
+	urlList       = "/list?prefix=%s&cursor=%s&limit=%d"
+	urlMeta       = "/meta?md5=%s"
 )
 
 type remoteStore struct {
 }
 
-func (r remoteStore) write(f multipart.File, md5 string, name string) error {
-	var buf bytes.Buffer
-	mt := multipart.NewWriter(&buf)
-	fileWriter, err := mt.CreateFormFile(md5, name)
-	if err != nil {
+func (r remoteStore) write(f *os.File, md5 string, name string) error {
+	if _, err := f.Seek(0, 0); err != nil {
 		return err
 	}
-	f.Seek(0, 0)
-	fileReader := bufio.NewReader(f)
-	fileReader.WriteTo(fileWriter)
-	mt.Close()
 
-	contentType := "multipart/form-data;boundary=" + mt.Boundary()
-	url := imagePath + urlDerectUp
-	req, err := http.NewRequest("POST", url, &buf)
+	//边读文件边发送，不在内存中拼接整个请求
+	pr, pw := io.Pipe()
+	mt := multipart.NewWriter(pw)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fileWriter, err := mt.CreateFormFile(md5, name)
+		if err == nil {
+			_, err = io.Copy(fileWriter, f)
+		}
+		if err == nil {
+			err = mt.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	//服务端可能提前回复，须等发送协程退出后才能再读f
+	defer func() {
+		pr.Close()
+		<-done
+	}()
+
+	url := urlDerectUp
+	if signer != nil {
+		url = signer("POST", url)
+	}
+	req, err := http.NewRequest("POST", imagePath+url, pr)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Type", mt.FormDataContentType())
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		return backendError(err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		err = errors.New(resp.Status)
+		return statusError(resp)
 	}
-	return err
+	return nil
 }
 
-func (r remoteStore) read(md5Code string, fileName *string) ([]byte, error) {
-	url := fmt.Sprintf(urlSimpleDown, md5Code)
+//远程存储时临时文件放在系统临时目录
+func (r remoteStore) tempDir() (string, error) {
+	return os.TempDir(), nil
+}
+
+func (r remoteStore) read(md5Code string, fileName *string) (*File, error) {
+	u := fmt.Sprintf(urlSimpleDown, url.QueryEscape(md5Code))
 	if *fileName != "" {
-		url = fmt.Sprintf(urlFullDown, md5Code, *fileName)
+		u = fmt.Sprintf(urlFullDown, url.QueryEscape(md5Code), url.QueryEscape(*fileName))
+	}
+	if signer != nil {
+		u = signer("GET", u)
 	}
 
-	resp, err := http.Get(imagePath + url)
+	//先只取文件头，够判断格式及exif方向，其余内容在读取时按位置请求
+	f := &remoteFile{url: imagePath + u, chunk: headSize}
+	resp, err := f.get(headSize - 1)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+
+	f.size = resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		f.size = rangeTotal(resp.Header.Get("Content-Range"))
+	}
+
+	//长度未知时无法按需读取，只能整体读入
+	if f.size < 0 {
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return bytesFile(data, lastModified(resp)), nil
+	}
+
+	f.setBody(resp.Body, 0)
+	return &File{ReadSeeker: f, Size: f.size, ModTime: lastModified(resp), closer: f}, nil
+}
+
+//解析Content-Range中的总长度，格式为bytes start-end/total，未知时返回-1
+func rangeTotal(contentRange string) int64 {
+	i := strings.LastIndexByte(contentRange, '/')
+	if !strings.HasPrefix(contentRange, "bytes ") || i < 0 {
+		return -1
+	}
+	n, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+//响应的修改时间，没有时为零值
+func lastModified(resp *http.Response) time.Time {
+	t, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return t
+}
+
+//远程原图，按读取位置发起带Range的请求，不在内存中缓存整个响应
+type remoteFile struct {
+	url     string
+	size    int64
+	pos     int64         //Seek设置的读取位置
+	body    io.ReadCloser //当前响应
+	br      *bufio.Reader
+	bodyPos int64 //当前响应读到的位置
+	chunk   int64 //上次请求的长度，顺序读取时倍增，Seek后重新开始
+}
+
+//顺序读取时单次请求的最大长度
+const maxChunk = 4 * 1024 * 1024
+
+//请求pos开始到end的内容
+func (f *remoteFile) get(end int64) (*http.Response, error) {
+	req, err := http.NewRequest("GET", f.url, nil)
 	if err != nil {
 		return nil, err
 	}
-	return body, nil
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", f.pos, end))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, backendError(err)
+	}
+
+	//错误页面不能当作图像内容，直接关闭
+	switch resp.StatusCode {
+	case http.StatusOK:
+		//不支持Range时跳过前面的内容
+		if f.pos > 0 {
+			if _, err := io.CopyN(ioutil.Discard, resp.Body, f.pos); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		return resp, nil
+	case http.StatusPartialContent:
+		return resp, nil
+	}
+	resp.Body.Close()
+	return nil, statusError(resp)
+}
+
+func (f *remoteFile) setBody(body io.ReadCloser, pos int64) {
+	f.body = body
+	f.br = bufio.NewReaderSize(body, headSize)
+	f.bodyPos = pos
+}
+
+func (f *remoteFile) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+
+	//读取位置已被Seek改变，重新请求
+	if f.bodyPos != f.pos {
+		if f.body != nil {
+			f.body.Close()
+			f.body = nil
+		}
+		f.chunk = 0
+	}
+	if f.body == nil {
+		resp, err := f.get(f.nextEnd(len(p)))
+		if err != nil {
+			return 0, err
+		}
+		f.setBody(resp.Body, f.pos)
+	}
+
+	n, err := f.br.Read(p)
+	f.pos += int64(n)
+	f.bodyPos = f.pos
+
+	//只请求了部分内容，读完后下次重新请求
+	if err == io.EOF && f.pos < f.size {
+		f.body.Close()
+		f.body = nil
+		if n == 0 {
+			return f.Read(p)
+		}
+		err = nil
+	}
+	return n, err
+}
+
+//下次请求的结束位置，按块请求，小范围读取时不必传输整个文件尾部，
+//顺序读取时块长度倍增以减少请求次数
+func (f *remoteFile) nextEnd(n int) int64 {
+	f.chunk *= 2
+	if f.chunk < headSize {
+		f.chunk = headSize
+	}
+	if f.chunk > maxChunk {
+		f.chunk = maxChunk
+	}
+	if f.chunk < int64(n) {
+		f.chunk = int64(n)
+	}
+	end := f.pos + f.chunk - 1
+	if end >= f.size {
+		end = f.size - 1
+	}
+	return end
+}
+
+//只记录位置，实际读取时才发起请求，http.ServeContent求长度时不产生请求
+func (f *remoteFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek位置为负")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+//预读文件头，仅在尚未读取时有效
+func (f *remoteFile) Peek(n int) ([]byte, error) {
+	if f.body == nil || f.bodyPos != 0 {
+		return nil, errors.New("只能在读取前预读")
+	}
+	return f.br.Peek(n)
+}
+
+func (f *remoteFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
+
+func (r remoteStore) remove(md5Code string, fileName string) error {
+	u := fmt.Sprintf(urlDelete, url.QueryEscape(md5Code), url.QueryEscape(fileName))
+	if signer != nil {
+		u = signer("DELETE", u)
+	}
+	req, err := http.NewRequest("DELETE", imagePath+u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return backendError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return statusError(resp)
+	}
+	return nil
+}
+
+//请求远程JSON接口并解码到v中
+func (r remoteStore) getJSON(u string, v interface{}) error {
+	resp, err := http.Get(imagePath + u)
+	if err != nil {
+		return backendError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return statusError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (r remoteStore) list(prefix, cursor string, limit int) (Page, error) {
+	var page Page
+	u := fmt.Sprintf(urlList, url.QueryEscape(prefix), url.QueryEscape(cursor), limit)
+	err := r.getJSON(u, &page)
+	return page, err
+}
+
+func (r remoteStore) stat(md5Code string) (Meta, error) {
+	var meta Meta
+	err := r.getJSON(fmt.Sprintf(urlMeta, url.QueryEscape(md5Code)), &meta)
+	return meta, err
 }
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//通过remoteStore读取测试服务器上的内容，rangeOK为false时服务器忽略Range，
+//返回服务器收到的各请求的Range头
+func readRemote(t *testing.T, content string, rangeOK bool) (*File, *[]string, func()) {
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.Header.Get("Range"))
+		if !rangeOK {
+			req.Header.Del("Range")
+		}
+		http.ServeContent(w, req, "", time.Time{}, strings.NewReader(content))
+	}))
+
+	old := imagePath
+	imagePath = ts.URL
+	name := ""
+	f, err := remoteStore{}.read("685264ff36effb53d7ecdb81d3b89b22", &name)
+	imagePath = old
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return f, &requests, func() {
+		f.Close()
+		ts.Close()
+	}
+}
+
+func TestRemoteFileSeek(t *testing.T) {
+	content := strings.Repeat("0123456789", 30000)
+	for _, rangeOK := range []bool{true, false} {
+		f, requests, done := readRemote(t, content, rangeOK)
+
+		//预读不移动位置
+		if head := f.Head(10); string(head) != content[:10] {
+			t.Errorf("预读错误 %q", head)
+		}
+
+		//读完首次请求的文件头后接着请求其余部分
+		all, err := ioutil.ReadAll(f)
+		if err != nil || !bytes.Equal(all, []byte(content)) {
+			t.Errorf("完整读取错误 %d %v", len(all), err)
+		}
+
+		//求长度不产生请求
+		if n, err := f.Seek(0, io.SeekEnd); err != nil || n != int64(len(content)) || f.Size != n {
+			t.Errorf("长度错误 %d %d %v", n, f.Size, err)
+		}
+		if rangeOK && len(*requests) != 2 {
+			t.Errorf("预期请求2次, 实际%d次", len(*requests))
+		}
+
+		f.Seek(250000, io.SeekStart)
+		b := make([]byte, 10)
+		if _, err := io.ReadFull(f, b); err != nil || string(b) != content[250000:250010] {
+			t.Errorf("Seek后读取错误 %q %v", b, err)
+		}
+		done()
+	}
+}
+
+//Seek后小范围读取只请求所需的块，不请求整个文件尾部
+func TestRemoteFileRange(t *testing.T) {
+	content := strings.Repeat("0123456789", 100000)
+	f, requests, done := readRemote(t, content, true)
+	defer done()
+
+	f.Seek(500000, io.SeekStart)
+	b := make([]byte, 10)
+	if _, err := io.ReadFull(f, b); err != nil || string(b) != content[500000:500010] {
+		t.Errorf("Seek后读取错误 %q %v", b, err)
+	}
+	want := fmt.Sprintf("bytes=500000-%d", 500000+headSize-1)
+	if got := (*requests)[len(*requests)-1]; got != want {
+		t.Errorf("预期[%s], 实际[%s]", want, got)
+	}
+
+	//顺序读取时请求长度倍增，不超过maxChunk
+	f.Seek(0, io.SeekStart)
+	*requests = nil
+	all, err := ioutil.ReadAll(f)
+	if err != nil || !bytes.Equal(all, []byte(content)) {
+		t.Errorf("完整读取错误 %d %v", len(all), err)
+	}
+	if len(*requests) != 4 || (*requests)[3] != fmt.Sprintf("bytes=%d-999999", 7*headSize) {
+		t.Errorf("非预期请求 %q", *requests)
+	}
+}
+
+func TestRangeTotal(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+	}{
+		{"bytes 0-99/1000", 1000},
+		{"bytes 0-99/*", -1},
+		{"", -1},
+		{"items 0-1/2", -1},
+	}
+	for _, test := range tests {
+		if got := rangeTotal(test.header); got != test.want {
+			t.Errorf("%q: 预期[%d], 实际[%d]", test.header, test.want, got)
+		}
+	}
+}
+
+func TestRemoteStatusError(t *testing.T) {
+	data := readOrientation(t, 1)
+	status := http.StatusInternalServerError
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			w.Write([]byte("错误页面"))
+			return
+		}
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer ts.Close()
+
+	Init(ts.URL, false, 1)
+	defer Init("", true, 0)
+
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusRequestEntityTooLarge, ErrTooLarge},
+		{http.StatusForbidden, ErrBackend},
+		{http.StatusBadGateway, ErrBackend},
+	}
+	const md5Code = "0123456789abcdef0123456789abcdef"
+	for _, test := range tests {
+		status = test.status
+		name := "a.jpg"
+		if _, err := Read(md5Code, &name, Option{Width: 10}); !errors.Is(err, test.want) {
+			t.Errorf("%d: 预期[%v], 实际[%v]", test.status, test.want, err)
+		}
+		if _, err := Stat(md5Code); !errors.Is(err, test.want) {
+			t.Errorf("%d: 预期[%v], 实际[%v]", test.status, test.want, err)
+		}
+	}
+
+	//错误页面不能进入缓存，服务恢复后可正常读取
+	status = http.StatusOK
+	name := "a.jpg"
+	f, err := Read(md5Code, &name, Option{Width: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	//连接失败
+	ts.Close()
+	name = "b.jpg"
+	if _, err := Read(md5Code, &name, Option{}); !errors.Is(err, ErrBackend) {
+		t.Errorf("预期[%v], 实际[%v]", ErrBackend, err)
+	}
+}
+
+//文件名中的特殊字符须转义，后端收到的文件名与请求的一致
+func TestRemoteFileName(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.URL.Query().Get("file_name")
+		http.ServeContent(w, req, "", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer ts.Close()
+
+	old := imagePath
+	imagePath = ts.URL
+	defer func() { imagePath = old }()
+	for _, name := range []string{"a b.jpg", "a&b.jpg", "a#b.jpg", "a+b.jpg", "测试.jpg"} {
+		n := name
+		f, err := remoteStore{}.read("685264ff36effb53d7ecdb81d3b89b22", &n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if got != name {
+			t.Errorf("预期[%s], 实际[%s]", name, got)
+		}
+	}
+}
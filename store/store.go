@@ -1,111 +1,208 @@
 package store
 
 import (
-	"bytes"
 	"errors"
 	"image"
-	"image/gif"
-	"image/jpeg"
-	"image/png"
-	"log"
-	"mime/multipart"
-	"strconv"
-
-	"github.com/DDHax/sis/store/graphics"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
 )
 
 //FileIO 文件读写接口
 type fileIO interface {
-	write(f multipart.File, md5 string, name string) error
-	read(md5Code string, fileName *string) ([]byte, error)
+	write(f *os.File, md5 string, name string) error
+	tempDir() (string, error)
+	read(md5Code string, fileName *string) (*File, error)
+	remove(md5Code string, fileName string) error
+	list(prefix, cursor string, limit int) (Page, error)
+	stat(md5Code string) (Meta, error)
+}
+
+//FileInfo 单个原始文件的元数据
+type FileInfo struct {
+	Name       string    //原始文件名
+	Size       int64     //文件字节数
+	Format     string    //图像格式，无法识别时为空
+	Width      int       //图像宽度
+	Height     int       //图像高度
+	UploadTime time.Time //上传时间
+}
+
+//Meta 同一md5下全部原始文件的元数据
+type Meta struct {
+	MD5   string
+	Files []FileInfo
+}
+
+//Page 分页列表，Next非空时表示还有下一页，作为下次请求的cursor
+type Page struct {
+	Items []string
+	Next  string
 }
 
 var imagePath string
 var storer fileIO
 
-//Write 写入图像文件接口
-func Write(f multipart.File, md5 string, name string) error {
+//远程存储时为请求后端的地址签名，参数为请求方法及路径加查询串，为nil时不签名
+var signer func(method, u string) string
+
+//Write 写入图像文件接口，f为TempFile创建的临时文件，本地存储时直接移动到位，
+//之后调用方只需关闭并尝试删除f。返回文件大小、格式及尺寸
+func Write(f *os.File, md5 string, name string) (FileInfo, error) {
+	info := FileInfo{Name: name, UploadTime: time.Now()}
+
+	//检查原图尺寸
+	if err := checkUpload(f); err != nil {
+		return info, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return info, err
+	}
+	info.Size = stat.Size()
+	info.Format, info.Width, info.Height = decodeConfig(f)
+
 	//落地写入
-	err := storer.write(f, md5, name)
+	err = storer.write(f, md5, name)
 
 	//写入缓存
 	if err == nil && gCache.isEnable() {
 		//log.Printf("写入cache %v %v", md5, name)
-		gCache.write(f, md5, name)
+		gCache.write(f, md5, name, stat.ModTime())
 	}
-	return err
-}
 
-func scaleImage(data []byte, destW, destH int) ([]byte, error) {
-	//解码原始图像
-	img, imgType, err := image.Decode(bytes.NewReader(data))
-
-	//建立目标图形
-	dst := image.NewRGBA(image.Rect(0, 0, destW, destH))
+	//后台预生成各预设
+	if err == nil {
+		enqueueWarm(md5, name)
+	}
+	return info, err
+}
 
-	//执行缩放
-	err = graphics.Scale(dst, img)
+//TempFile 创建接收上传内容的临时文件，本地存储时位于存储目录下，以便直接移动到位
+func TempFile() (*os.File, error) {
+	dir, err := storer.tempDir()
 	if err != nil {
 		return nil, err
 	}
-
-	//编码缩放后图像
-	var buf bytes.Buffer
-	switch imgType {
-	case "jpg", "jpeg":
-		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 100})
-	case "png":
-		err = png.Encode(&buf, dst)
-	case "gif":
-		err = gif.Encode(&buf, dst, nil)
-	default:
-		log.Print(imgType)
-		err = errors.New("找不到编码器")
-	}
-	return buf.Bytes(), err
+	return ioutil.TempFile(dir, "upload-")
 }
 
-//Read 读取图像文件接口
-func Read(md5Code string, fileName *string, width, height int) ([]byte, error) {
+//Read 读取图像文件接口，opt为零值时读取原始文件，返回的File用完须关闭
+func Read(md5Code string, fileName *string, opt Option) (*File, error) {
+	if !opt.Valid() {
+		return nil, ErrBadOption
+	}
 
 	key := md5Code + *fileName
-	longKey := key
-	if width > 0 && height > 0 {
-		longKey = longKey + strconv.Itoa(width) + "_" + strconv.Itoa(height)
-	}
+	longKey := key + opt.key()
 
 	//读取缓存
 	if gCache.isEnable() {
-		data, err := gCache.read(longKey)
+		data, modTime, err := gCache.read(longKey)
 		if err == nil {
 			//log.Printf("命中cache %v %v", md5Code, fileName)
-			return data, err
+			f := bytesFile(data, modTime)
+			f.ETag = etag(md5Code, opt)
+			return f, nil
 		}
 	}
 
-	//读原始文件
-	data, err := storer.read(md5Code, fileName)
-	if err == nil && width > 0 && height > 0 {
-		//图像缩放
-		dst, err := scaleImage(data, width, height)
-		if err == nil && gCache.isEnable() {
-			//写入缓存
-			gCache.memWrite(longKey, dst)
+	//读原始文件，上传时已写入缓存的优先
+	var f *File
+	if gCache.isEnable() {
+		if data, modTime, err := gCache.read(key); err == nil {
+			f = bytesFile(data, modTime)
 		}
-		return dst, err
 	}
+	if f == nil {
+		var err error
+		f, err = storer.read(md5Code, fileName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//既无处理参数也无需旋转时直接返回原图，由调用方流式读取
+	if !opt.Processed() && opt.orientation(f.Head(headSize)) == 1 {
+		f.ETag = etag(md5Code, opt)
+		return f, nil
+	}
+
+	//图像处理需要完整内容
+	data, err := f.bytes()
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	//图像旋转、缩放及格式转换
+	dst, err := processImage(data, opt)
+	if err != nil {
+		return nil, err
+	}
+	if gCache.isEnable() {
+		//写入缓存
+		gCache.memWrite(longKey, dst, f.ModTime)
+	}
+	f = bytesFile(dst, f.ModTime)
+	f.ETag = etag(md5Code, opt)
+	return f, nil
+}
+
+//Delete 删除图像文件接口，fileName为空时删除该md5下的全部文件
+func Delete(md5Code string, fileName string) error {
+	//md5不完整时按前缀删除的目录会包含其他文件
+	if !validMD5(md5Code) {
+		return errors.New("md5格式错误")
+	}
+
+	//清理缓存，同一md5下的缩放图一并清除
+	if gCache.isEnable() {
+		gCache.removePrefix(md5Code)
+	}
+
+	//删除落地文件
+	return storer.remove(md5Code, fileName)
+}
 
-	return data, err
+//List 分页列出已存储的md5，按字典序返回大于cursor且以prefix开头的至多limit项
+func List(prefix, cursor string, limit int) (Page, error) {
+	if limit <= 0 {
+		return Page{}, errors.New("limit必须大于0")
+	}
+	return storer.list(prefix, cursor, limit)
+}
+
+//Stat 读取md5下全部原始文件的元数据
+func Stat(md5Code string) (Meta, error) {
+	if md5Code == "" {
+		return Meta{}, errors.New("md5不能为空")
+	}
+	return storer.stat(md5Code)
+}
+
+//读取图像格式和尺寸，无法识别时返回空值
+func decodeConfig(r io.Reader) (format string, width, height int) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return "", 0, 0
+	}
+	return format, config.Width, config.Height
 }
 
 //Init 初始化接口，设置存储路径和类型
 func Init(path string, isLocal bool, cacheSize int) {
 	imagePath = path
-	gCache.maxSize = int64(cacheSize) * 1024 * 1024
-	gCache.data = make(map[string][]byte)
+	gCache.init(int64(cacheSize) * 1024 * 1024)
 	if isLocal {
 		storer, _ = storer.(localStore)
 	} else {
 		storer, _ = storer.(remoteStore)
 	}
 }
+
+//SetSigner 设置远程存储请求后端时的签名函数，参数为请求方法及路径加查询串，返回签名后的路径加查询串
+func SetSigner(f func(method, u string) string) {
+	signer = f
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/DDHax/sis/store/graphics"
+	"github.com/DDHax/sis/store/graphics/interp"
+)
+
+//Op 缩放前按顺序执行的图像变换
+type Op interface {
+	apply(img image.Image, i interp.Interp) (image.Image, error)
+	size(w, h int) (int, int) //变换后的尺寸，用于解码前检查
+	valid() bool
+	key() string
+}
+
+//Crop 裁剪，坐标相对于原图左上角
+type Crop struct {
+	X, Y, Width, Height int
+}
+
+//Rotate 按角度顺时针旋转，非直角旋转时空白处以背景色填充
+type Rotate struct {
+	Degrees    float64
+	Background color.RGBA
+}
+
+//Flip 翻转
+type Flip struct {
+	Vertical bool //为false时水平翻转
+}
+
+//Resize 缩放，作为一步变换时可与其他变换任意排序
+type Resize struct {
+	Width, Height int
+	Mode          string
+	Background    color.RGBA
+}
+
+//ParseOp 解析变换参数：crop=x,y,w,h、rotate=deg、flip=h|v，bg为旋转的背景色
+func ParseOp(name, value string, bg color.RGBA) (Op, error) {
+	switch name {
+	case "crop":
+		s := strings.Split(value, ",")
+		if len(s) != 4 {
+			return nil, errors.New("裁剪参数格式错误")
+		}
+		var n [4]int
+		for i := range s {
+			v, err := strconv.Atoi(s[i])
+			if err != nil {
+				return nil, errors.New("裁剪参数格式错误")
+			}
+			n[i] = v
+		}
+		return Crop{n[0], n[1], n[2], n[3]}, nil
+	case "rotate":
+		deg, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(deg) || math.IsInf(deg, 0) {
+			return nil, errors.New("旋转角度格式错误")
+		}
+		return Rotate{deg, bg}, nil
+	case "flip":
+		switch value {
+		case "h":
+			return Flip{}, nil
+		case "v":
+			return Flip{Vertical: true}, nil
+		}
+		return nil, errors.New("翻转方向错误")
+	}
+	return nil, errors.New("未知的变换：" + name)
+}
+
+func (c Crop) valid() bool {
+	return c.X >= 0 && c.Y >= 0 && c.Width > 0 && c.Height > 0 &&
+		c.X <= MaxSize && c.Y <= MaxSize && c.Width <= MaxSize && c.Height <= MaxSize
+}
+
+func (c Crop) key() string {
+	return "_c" + strconv.Itoa(c.X) + "," + strconv.Itoa(c.Y) + "," +
+		strconv.Itoa(c.Width) + "," + strconv.Itoa(c.Height)
+}
+
+func (c Crop) size(w, h int) (int, int) {
+	r := image.Rect(c.X, c.Y, c.X+c.Width, c.Y+c.Height).Intersect(image.Rect(0, 0, w, h))
+	return r.Dx(), r.Dy()
+}
+
+func (c Crop) apply(img image.Image, i interp.Interp) (image.Image, error) {
+	b := img.Bounds()
+	r := image.Rect(c.X, c.Y, c.X+c.Width, c.Y+c.Height).Add(b.Min).Intersect(b)
+	if r.Empty() {
+		return nil, errors.New("裁剪区域超出图像范围")
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst, nil
+}
+
+//角度归一化到[0, 360)
+func (r Rotate) degrees() float64 {
+	d := math.Mod(r.Degrees, 360)
+	if d < 0 {
+		d += 360
+	}
+	return d
+}
+
+func (r Rotate) valid() bool {
+	return true
+}
+
+func (r Rotate) key() string {
+	d := r.degrees()
+	key := "_r" + strconv.FormatFloat(d, 'g', -1, 64)
+	if math.Mod(d, 90) != 0 {
+		c := r.Background
+		key = key + "_" + hex.EncodeToString([]byte{c.R, c.G, c.B, c.A})
+	}
+	return key
+}
+
+//旋转后的外接矩形尺寸
+func (r Rotate) size(w, h int) (int, int) {
+	switch d := r.degrees(); d {
+	case 0, 180:
+		return w, h
+	case 90, 270:
+		return h, w
+	}
+	angle := r.degrees() * math.Pi / 180
+	sin, cos := math.Abs(math.Sin(angle)), math.Abs(math.Cos(angle))
+	return scaleLength(1, float64(w)*cos+float64(h)*sin), scaleLength(1, float64(w)*sin+float64(h)*cos)
+}
+
+func (r Rotate) apply(img image.Image, i interp.Interp) (image.Image, error) {
+	//直角旋转与exif方向摆正相同，无需插值
+	switch d := r.degrees(); d {
+	case 0:
+		return img, nil
+	case 90:
+		return orient(img, 6)
+	case 180:
+		return orient(img, 3)
+	case 270:
+		return orient(img, 8)
+	}
+
+	//目标尺寸为旋转后的外接矩形
+	b := img.Bounds()
+	w, h := r.size(b.Dx(), b.Dy())
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{r.Background}, image.Point{}, draw.Src)
+
+	if i == nil {
+		i = interp.Bilinear
+	}
+	angle := r.degrees() * math.Pi / 180
+	err := graphics.I.Rotate(angle).CenterFit(dst.Bounds(), b).Transform(dst, img, i)
+	return dst, err
+}
+
+func (f Flip) valid() bool {
+	return true
+}
+
+func (f Flip) key() string {
+	if f.Vertical {
+		return "_flipv"
+	}
+	return "_fliph"
+}
+
+func (f Flip) size(w, h int) (int, int) {
+	return w, h
+}
+
+func (f Flip) apply(img image.Image, i interp.Interp) (image.Image, error) {
+	if f.Vertical {
+		return orient(img, 4)
+	}
+	return orient(img, 2)
+}
+
+//对应的缩放参数
+func (r Resize) option() Option {
+	return Option{Width: r.Width, Height: r.Height, Mode: r.Mode, Background: r.Background}
+}
+
+func (r Resize) valid() bool {
+	return r.option().scaled() && r.option().Valid()
+}
+
+func (r Resize) key() string {
+	return "_rs" + r.option().key()
+}
+
+func (r Resize) size(w, h int) (int, int) {
+	return r.option().size(w, h)
+}
+
+func (r Resize) apply(img image.Image, i interp.Interp) (image.Image, error) {
+	return resize(img, r.option(), i)
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+var (
+	red   = color.RGBA{0xff, 0, 0, 0xff}
+	green = color.RGBA{0, 0xff, 0, 0xff}
+	white = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+//左半红右半绿的40x20图像
+func newHalves() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			if x < 20 {
+				img.SetRGBA(x, y, red)
+			} else {
+				img.SetRGBA(x, y, green)
+			}
+		}
+	}
+	return img
+}
+
+func TestParseOp(t *testing.T) {
+	tests := []struct {
+		name, value string
+		op          Op
+	}{
+		{"crop", "1,2,3,4", Crop{1, 2, 3, 4}},
+		{"rotate", "-90", Rotate{-90, white}},
+		{"flip", "h", Flip{}},
+		{"flip", "v", Flip{Vertical: true}},
+		{"crop", "1,2,3", nil},
+		{"rotate", "NaN", nil},
+		{"flip", "x", nil},
+		{"shear", "1", nil},
+	}
+	for _, test := range tests {
+		op, err := ParseOp(test.name, test.value, white)
+		if (err == nil) != (test.op != nil) || op != test.op {
+			t.Errorf("%s=%s: 预期[%v], 实际[%v %v]", test.name, test.value, test.op, op, err)
+		}
+	}
+	if (Option{Ops: []Op{Crop{0, 0, 0, 10}}}).Valid() {
+		t.Error("裁剪宽度为0时应不合法")
+	}
+}
+
+func TestTransformOrder(t *testing.T) {
+	tests := []struct {
+		ops  []Op
+		size image.Point
+		x, y int
+		want color.RGBA
+	}{
+		//裁出左半再顺时针旋转，结果全红
+		{[]Op{Crop{0, 0, 20, 20}, Rotate{Degrees: 90}}, image.Pt(20, 20), 10, 10, red},
+		//先旋转再裁剪，绿色转到下方
+		{[]Op{Rotate{Degrees: 90}, Crop{0, 20, 20, 20}}, image.Pt(20, 20), 10, 10, green},
+		{[]Op{Flip{}}, image.Pt(40, 20), 5, 10, green},
+		{[]Op{Flip{Vertical: true}}, image.Pt(40, 20), 5, 10, red},
+		{[]Op{Rotate{Degrees: 450}}, image.Pt(20, 40), 10, 5, red},
+		//非直角旋转的外接矩形，角落为背景色
+		{[]Op{Rotate{Degrees: 45, Background: white}}, image.Pt(42, 42), 1, 1, white},
+	}
+	for i, test := range tests {
+		img, err := transform(newHalves(), Option{Ops: test.ops})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := img.Bounds().Size(); s != test.size {
+			t.Errorf("%d: 尺寸错误, 预期[%v], 实际[%v]", i, test.size, s)
+			continue
+		}
+		if c := img.At(test.x, test.y); c != test.want {
+			t.Errorf("%d: (%d, %d)颜色错误, 预期[%v], 实际[%v]", i, test.x, test.y, test.want, c)
+		}
+	}
+
+	if _, err := transform(newHalves(), Option{Ops: []Op{Crop{50, 0, 10, 10}}}); err == nil {
+		t.Error("裁剪区域超出图像时应返回错误")
+	}
+}
+
+func TestOpsKey(t *testing.T) {
+	a := Option{Ops: []Op{Crop{0, 0, 20, 20}, Rotate{Degrees: 90}}}
+	b := Option{Ops: []Op{Rotate{Degrees: 90}, Crop{0, 0, 20, 20}}}
+	if a.key() == b.key() {
+		t.Error("变换顺序不同应分别缓存")
+	}
+	if k := (Option{Ops: []Op{Rotate{Degrees: -270}}}).key(); k != "_r90" {
+		t.Errorf("预期[_r90], 实际[%s]", k)
+	}
+	if k := (Option{Width: 20, Ops: []Op{Flip{}}}).key(); k != "20_0_fliph" {
+		t.Errorf("预期[20_0_fliph], 实际[%s]", k)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -15,14 +16,54 @@ import (
 )
 
 const (
+	urlHost              = "http://127.0.0.1:3333"
 	urlUp                = "http://127.0.0.1:3333/up"
 	urlDerectUp          = "http://127.0.0.1:3333/derect_up"
 	urlSimpleDown        = "http://127.0.0.1:3333/simple_down?md5=%s"
 	urlStretchSimpleDown = "http://127.0.0.1:3333/stretch_simple_down?md5=%s&w=%d&h=%d"
 	urlFullDown          = "http://127.0.0.1:3333/full_down?md5=%s&file_name=%s"
 	urlStretchFullDown   = "http://127.0.0.1:3333/stretch_full_down?md5=%s&file_name=%s&w=%d&h=%d"
+	urlDelete            = "http://127.0.0.1:3333/delete?md5=%s&file_name=%s"
+	urlList              = "http://127.0.0.1:3333/list?prefix=%s&cursor=%s&limit=%d"
+	urlMeta              = "http://127.0.0.1:3333/meta?md5=%s"
+	urlTransform         = "http://127.0.0.1:3333/transform?md5=%s&%s"
+	urlImg               = "http://127.0.0.1:3333/img/%s/%s/%s"
+	urlImages            = "http://127.0.0.1:3333/v1/images/"
 )
 
+//错误回复
+type errorReply struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+//解析错误回复中的错误代码，不是错误回复时返回空串
+func errorCode(rep string) string {
+	var e errorReply
+	if json.Unmarshal([]byte(rep), &e) != nil {
+		return ""
+	}
+	return e.Code
+}
+
+//按指定语言请求，解析错误回复
+func getError(url, lang string) (int, *http.Response, errorReply, error) {
+	var e errorReply
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, nil, e, err
+	}
+	req.Header.Set("Accept-Language", lang)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, e, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&e)
+	return resp.StatusCode, resp, e, err
+}
+
 func singleUpload(fileName string) (string, error) {
 	file, err := os.Open(fileName)
 	if err != nil {
@@ -57,6 +98,30 @@ func singleUpload(fileName string) (string, error) {
 	return content, nil
 }
 
+//以指定的文件名上传本地文件，返回回复内容
+func uploadAs(fileName, name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	mt := multipart.NewWriter(&buf)
+	fileWriter, err := mt.CreateFormFile("upload_test", name)
+	if err != nil {
+		return nil, err
+	}
+	fileWriter.Write(data)
+	mt.Close()
+
+	resp, err := http.Post(urlUp, mt.FormDataContentType(), &buf)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
 func derectUpload(fileName string) error {
 	file, err := os.Open(fileName)
 	if err != nil {
@@ -74,13 +139,27 @@ func derectUpload(fileName string) error {
 	//16进制md5转字符串格式
 	md5Code := hex.EncodeToString(ret)
 
+	status, err := derectUploadAs(fileName, md5Code)
+	if err == nil && status != 200 {
+		err = errors.New(http.StatusText(status))
+	}
+	return err
+}
+
+//以指定的md5为字段名直接上传，返回状态码
+func derectUploadAs(fileName, md5Code string) (int, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
 	var buf bytes.Buffer
 	mt := multipart.NewWriter(&buf)
 	fileWriter, err := mt.CreateFormFile(md5Code, fileName)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	file.Seek(0, 0)
 	fileReader := bufio.NewReader(file)
 	fileReader.WriteTo(fileWriter)
 	mt.Close()
@@ -88,20 +167,16 @@ func derectUpload(fileName string) error {
 	contentType := "multipart/form-data;boundary=" + mt.Boundary()
 	req, err := http.NewRequest("POST", urlDerectUp, &buf)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	req.Header.Set("Content-Type", contentType)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		err = errors.New(resp.Status)
+		return 0, err
 	}
-
-	return err
+	resp.Body.Close()
+	return resp.StatusCode, nil
 }
 
 func multipleUpload(files []string) (string, error) {
@@ -196,3 +271,113 @@ func stretchFullDown(md5, fileName string, w, h int) ([]byte, error) {
 	}
 	return body, nil
 }
+
+func transform(md5, query string) ([]byte, error) {
+	url := fmt.Sprintf(urlTransform, md5, query)
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, errors.New(resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func img(pipeline, md5, fileName string) (int, []byte, error) {
+	url := fmt.Sprintf(urlImg, pipeline, md5, fileName)
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+//带If-None-Match请求原图
+func revalidate(md5, etag string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf(urlSimpleDown, md5), nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+//带Range请求原图，ifRange为空时不带If-Range
+func rangeDown(md5, ranges, ifRange string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf(urlSimpleDown, md5), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Range", ranges)
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+//REST接口请求，body为nil时不带请求体
+func rest(method, url string, body io.Reader) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	return resp, data, err
+}
+
+func deleteImage(md5, fileName string) (int, error) {
+	url := fmt.Sprintf(urlDelete, md5, fileName)
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return errors.New(resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func list(prefix, cursor string, limit int, v interface{}) error {
+	return getJSON(fmt.Sprintf(urlList, prefix, cursor, limit), v)
+}
+
+func meta(md5 string, v interface{}) error {
+	return getJSON(fmt.Sprintf(urlMeta, md5), v)
+}
@@ -5,10 +5,15 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
 	"strings"
 	"testing"
 )
@@ -87,15 +92,33 @@ func Test_derectUpload(t *testing.T) {
 	if md5Code != clientTests[4].md5 {
 		t.Fatal(err)
 	}
+
+	//字段名不是md5或与文件内容不符时拒绝
+	for _, name := range []string{"test", clientTests[0].md5} {
+		status, err := derectUploadAs(clientTests[4].fileName, name)
+		if err != nil || status != 400 {
+			t.Errorf("%s: 预期[400], 实际[%d %v]", name, status, err)
+		}
+	}
 }
 
 func Test_longFileName(t *testing.T) {
-	rep, err := singleUpload(clientTests[3].fileName)
+	//文件名过长只影响该文件，其他文件照常保存
+	rep, err := multipleUpload([]string{clientTests[4].fileName, clientTests[3].fileName})
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	if string(rep) != "文件名长度超出50字节限制" {
-		t.Fatal("长文件名未报错")
+
+	type Message struct {
+		Name, MD5, Code string
+	}
+	var ms []Message
+	if err := json.Unmarshal([]byte(rep), &ms); err != nil {
+		t.Fatalf("%v: %s", err, rep)
+	}
+	if len(ms) != 2 || ms[0].MD5 != clientTests[4].md5 || ms[1].Name != clientTests[3].fileName ||
+		ms[1].Code != "bad_file_name" || ms[1].MD5 != "" {
+		t.Fatalf("长文件名未报错 %s", rep)
 	}
 }
 
@@ -142,8 +165,87 @@ func Test_maxUpload(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if string(rep) != "上传文件超出50M限制" {
-		t.Fatal("上传内容超出服务设置未报错")
+	if code := errorCode(rep); code != "too_large" {
+		t.Fatalf("上传内容超出服务设置未报错 %s", rep)
+	}
+}
+
+func Test_badUpload(t *testing.T) {
+	rep, err := multipleUpload([]string{"fake.jpg", clientTests[4].fileName})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Message struct {
+		Name, MD5, Error string
+	}
+	var ms []Message
+	if err := json.Unmarshal([]byte(rep), &ms); err != nil {
+		t.Fatalf("%v: %s", err, rep)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("非预期返回值 %s", rep)
+	}
+	for _, m := range ms {
+		switch m.Name {
+		case "fake.jpg":
+			if m.Error == "" || m.MD5 != "" {
+				t.Errorf("伪装的图像未报错 %+v", m)
+			}
+		case clientTests[4].fileName:
+			if m.Error != "" || m.MD5 != clientTests[4].md5 {
+				t.Errorf("正常图像上传失败 %+v", m)
+			}
+		default:
+			t.Errorf("非预期文件 %+v", m)
+		}
+	}
+}
+
+func Test_uploadInfo(t *testing.T) {
+	//文件名中的引号和反斜杠须正确编码
+	const name = `a"b\c.jpg`
+	rep, err := uploadAs(clientTests[0].fileName, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Message struct {
+		Name, MD5, Format, URL string
+		Size                   int64
+		Width, Height          int
+	}
+	var ms []Message
+	if err := json.Unmarshal(rep, &ms); err != nil {
+		t.Fatalf("%v: %s", err, rep)
+	}
+	if len(ms) != 1 {
+		t.Fatalf("非预期返回值 %s", rep)
+	}
+	m := ms[0]
+
+	src, err := ioutil.ReadFile(clientTests[0].fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != name || m.MD5 != clientTests[0].md5 || m.Size != int64(len(src)) ||
+		m.Format != format || m.Width != config.Width || m.Height != config.Height {
+		t.Errorf("非预期返回值 %+v", m)
+	}
+
+	//按返回的地址可下载原图
+	resp, err := http.Get(urlHost + m.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || !bytes.Equal(data, src) {
+		t.Errorf("下载地址不可用 %s %d", m.URL, resp.StatusCode)
 	}
 }
 
@@ -209,6 +311,328 @@ func Test_stretchFullDown(t *testing.T) {
 	}
 }
 
+func Test_transform(t *testing.T) {
+	//test1.jpg为1152x648
+	tests := []struct {
+		query string
+		w, h  int
+	}{
+		{"crop=0,0,600,400&rotate=90", 400, 600},
+		{"rotate=90&crop=0,0,600,400", 600, 400},
+		{"flip=h&rotate=-90&w=162", 162, 288},
+	}
+	for _, test := range tests {
+		data, err := transform(clientTests[0].md5, test.query)
+		if err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != test.w || b.Dy() != test.h {
+			t.Fatalf("%s: 预期[%dx%d], 实际[%v]", test.query, test.w, test.h, b)
+		}
+	}
+
+	if _, err := transform(clientTests[0].md5, "flip=x"); err == nil {
+		t.Fatal("非法参数未返回错误")
+	}
+}
+
+func Test_img(t *testing.T) {
+	status, data, err := img("rs:fill:300:200/q:80/f:png/rot:90", clientTests[1].md5, clientTests[1].fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 200 {
+		t.Fatalf("非预期状态码 %d %s", status, data)
+	}
+	pic, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := pic.Bounds(); format != "png" || b.Dx() != 200 || b.Dy() != 300 {
+		t.Fatalf("非预期结果 %s %v", format, b)
+	}
+
+	//每个出错的步骤都应报告
+	status, data, err = img("rs:fill:300:200/q:0/f:bmp", clientTests[1].md5, clientTests[1].fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply errorReply
+	if err := json.Unmarshal(data, &reply); err != nil {
+		t.Fatalf("%v: %s", err, data)
+	}
+	if status != 400 || reply.Code != "bad_request" || reply.RequestID == "" ||
+		!strings.Contains(reply.Message, "第2步") || !strings.Contains(reply.Message, "第3步") {
+		t.Fatalf("非预期回复 %d %s", status, data)
+	}
+}
+
+func Test_revalidate(t *testing.T) {
+	resp, err := revalidate(clientTests[0].md5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != 200 || !strings.HasPrefix(etag, `"`+clientTests[0].md5) {
+		t.Fatalf("非预期回复 %d %q", resp.StatusCode, etag)
+	}
+	if resp.Header.Get("Last-Modified") == "" || !strings.Contains(resp.Header.Get("Cache-Control"), "immutable") {
+		t.Errorf("缺少缓存头 %v", resp.Header)
+	}
+
+	//ETag一致时回复304
+	resp, err = revalidate(clientTests[0].md5, etag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 304 {
+		t.Errorf("预期304, 实际%d", resp.StatusCode)
+	}
+}
+
+func Test_range(t *testing.T) {
+	md5Code := clientTests[0].md5
+	full, err := simpleDown(md5Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//单个范围
+	resp, body, err := rangeDown(md5Code, "bytes=100-199", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 206 || !bytes.Equal(body, full[100:200]) {
+		t.Fatalf("非预期回复 %d %d", resp.StatusCode, len(body))
+	}
+	if want := fmt.Sprintf("bytes 100-199/%d", len(full)); resp.Header.Get("Content-Range") != want {
+		t.Errorf("Content-Range错误 %s", resp.Header.Get("Content-Range"))
+	}
+
+	//文件末尾
+	resp, body, err = rangeDown(md5Code, "bytes=-10", "")
+	if err != nil || resp.StatusCode != 206 || !bytes.Equal(body, full[len(full)-10:]) {
+		t.Errorf("末尾范围错误 %v", err)
+	}
+
+	//多个范围
+	resp, body, err = rangeDown(md5Code, "bytes=0-9,1000-1009", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode != 206 || mediaType != "multipart/byteranges" {
+		t.Fatalf("非预期回复 %d %s", resp.StatusCode, mediaType)
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for _, want := range [][]byte{full[:10], full[1000:1010]} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := ioutil.ReadAll(part); !bytes.Equal(got, want) {
+			t.Errorf("分段内容错误 %s", part.Header.Get("Content-Range"))
+		}
+	}
+
+	//超出文件长度
+	resp, _, err = rangeDown(md5Code, fmt.Sprintf("bytes=%d-", len(full)), "")
+	if err != nil || resp.StatusCode != 416 {
+		t.Errorf("预期416, 实际%d %v", resp.StatusCode, err)
+	}
+
+	//If-Range与ETag一致时按范围回复，否则回复完整文件
+	etag := resp.Header.Get("ETag")
+	resp, body, err = rangeDown(md5Code, "bytes=0-9", etag)
+	if err != nil || resp.StatusCode != 206 || len(body) != 10 {
+		t.Errorf("If-Range一致时应回复206, 实际%d", resp.StatusCode)
+	}
+	resp, body, err = rangeDown(md5Code, "bytes=0-9", `"stale"`)
+	if err != nil || resp.StatusCode != 200 || !bytes.Equal(body, full) {
+		t.Errorf("If-Range不一致时应回复200, 实际%d", resp.StatusCode)
+	}
+}
+
+func Test_errorReply(t *testing.T) {
+	const missing = "00000000000000000000000000000000"
+	status, resp, reply, err := getError(fmt.Sprintf(urlSimpleDown, missing), "en-US,zh;q=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 404 || reply.Code != "not_found" || reply.Message != "file not found" {
+		t.Errorf("非预期回复 %d %+v", status, reply)
+	}
+	if reply.RequestID == "" || resp.Header.Get("X-Request-ID") != reply.RequestID {
+		t.Errorf("请求ID不一致 %s %s", reply.RequestID, resp.Header.Get("X-Request-ID"))
+	}
+
+	//缺省为中文
+	status, _, reply, err = getError(fmt.Sprintf(urlStretchSimpleDown, clientTests[0].md5, 0, 0), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 400 || reply.Code != "bad_request" || reply.Message != "请求参数错误" {
+		t.Errorf("非预期回复 %d %+v", status, reply)
+	}
+}
+
+func Test_list(t *testing.T) {
+	type Page struct {
+		Items []string
+		Next  string
+	}
+
+	//逐页读取，每页一项
+	var items []string
+	var cursor string
+	for {
+		var page Page
+		err := list("", cursor, 1, &page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) > 1 {
+			t.Fatalf("分页数量错误 %v", page.Items)
+		}
+		items = append(items, page.Items...)
+		if page.Next == "" {
+			break
+		}
+		cursor = page.Next
+	}
+	for i := 1; i < len(items); i++ {
+		if items[i-1] >= items[i] {
+			t.Fatalf("列表未按顺序返回 %v", items)
+		}
+	}
+
+	//按前缀过滤
+	var page Page
+	err := list(clientTests[0].md5[:3], "", 10, &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0] != clientTests[0].md5 {
+		t.Fatalf("前缀过滤结果错误 %v", page.Items)
+	}
+}
+
+func Test_meta(t *testing.T) {
+	type FileInfo struct {
+		Name          string
+		Size          int64
+		Format        string
+		Width, Height int
+	}
+	type Meta struct {
+		MD5   string
+		Files []FileInfo
+	}
+
+	var m Meta
+	err := meta(clientTests[1].md5, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.MD5 != clientTests[1].md5 || len(m.Files) == 0 {
+		t.Fatalf("非预期元数据 %+v", m)
+	}
+	for _, f := range m.Files {
+		if f.Name != clientTests[1].fileName {
+			continue
+		}
+		if f.Format != "png" || f.Size <= 0 || f.Width <= 0 || f.Height <= 0 {
+			t.Fatalf("非预期元数据 %+v", f)
+		}
+		return
+	}
+	t.Fatalf("元数据中未找到文件 %s", clientTests[1].fileName)
+}
+
+func Test_rest(t *testing.T) {
+	src, err := ioutil.ReadFile(clientTests[2].fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//以请求体上传
+	resp, data, err := rest("PUT", urlImages+"rest.gif", bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m struct {
+		Name, MD5, Format, URL string
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("%v: %s", err, data)
+	}
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != 201 || m.MD5 != clientTests[2].md5 || m.Format != "gif" || location != m.URL {
+		t.Fatalf("非预期回复 %d %s", resp.StatusCode, data)
+	}
+
+	//读取原图及处理结果
+	resp, data, err = rest("GET", urlHost+location, nil)
+	if err != nil || resp.StatusCode != 200 || !bytes.Equal(data, src) {
+		t.Fatalf("读取失败 %d %v", resp.StatusCode, err)
+	}
+	resp, data, err = rest("HEAD", urlHost+location, nil)
+	if err != nil || resp.StatusCode != 200 || len(data) != 0 || resp.ContentLength != int64(len(src)) {
+		t.Errorf("HEAD回复错误 %d %d", resp.StatusCode, resp.ContentLength)
+	}
+	resp, data, err = rest("GET", urlImages+m.MD5+"?w=50&h=50&format=png", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pic, format, err := image.Decode(bytes.NewReader(data)); err != nil || format != "png" || pic.Bounds().Dx() != 50 {
+		t.Errorf("处理结果错误 %d %v", resp.StatusCode, err)
+	}
+
+	//不支持的方法
+	resp, _, err = rest("POST", urlHost+location, nil)
+	if err != nil || resp.StatusCode != 405 || !strings.Contains(resp.Header.Get("Allow"), "DELETE") {
+		t.Errorf("预期405, 实际%d %v", resp.StatusCode, err)
+	}
+
+	//删除后不存在
+	resp, _, err = rest("DELETE", urlHost+location, nil)
+	if err != nil || resp.StatusCode != 204 {
+		t.Fatalf("删除失败 %d %v", resp.StatusCode, err)
+	}
+	resp, data, err = rest("GET", urlHost+location, nil)
+	if err != nil || resp.StatusCode != 404 || errorCode(string(data)) != "not_found" {
+		t.Errorf("删除后仍可读取 %d %s", resp.StatusCode, data)
+	}
+}
+
+func Test_delete(t *testing.T) {
+	err := derectUpload(clientTests[4].fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := deleteImage(clientTests[4].md5, clientTests[4].fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 200 {
+		t.Fatalf("删除失败, 状态码[%d]", status)
+	}
+
+	//再次删除应返回文件不存在
+	status, err = deleteImage(clientTests[4].md5, clientTests[4].fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 404 {
+		t.Fatalf("重复删除未报错, 状态码[%d]", status)
+	}
+}
+
 func BenchmarkUp(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		rep, err := singleUpload(clientTests[0].fileName)